@@ -0,0 +1,661 @@
+//
+// bibtex.go - writes BibTeX entries for the repository links kept in a worksheet and reads BibTeX files back into one.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// bibTeXTypes maps EPrints record types to BibTeX entry types, anything else is "misc"
+	bibTeXTypes = map[string]string{
+		"article":         "article",
+		"book":            "book",
+		"book_section":    "incollection",
+		"conference_item": "inproceedings",
+		"monograph":       "techreport",
+		"thesis":          "phdthesis",
+	}
+
+	// bibTeXEscape escapes the characters BibTeX treats specially
+	bibTeXEscape = strings.NewReplacer(
+		`\`, `\textbackslash{}`,
+		`&`, `\&`,
+		`%`, `\%`,
+		`$`, `\$`,
+		`#`, `\#`,
+		`_`, `\_`,
+		`{`, `\{`,
+		`}`, `\}`,
+	)
+)
+
+// keyPart lower cases a name or word and keeps only ASCII letters and digits
+func keyPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, foldDiacritics(s))
+}
+
+// CitationKey returns a citation key built from the record's first author, year and
+// first significant title word, e.g. "feynman1959plenty". The same record always gets
+// the same key, records without enough metadata fall back to "eprint" plus the eprint id.
+func CitationKey(r *Record) string {
+	key := ""
+	if len(r.Creators) > 0 {
+		key = keyPart(r.Creators[0].Family)
+	}
+	key += r.Year()
+	for _, word := range strings.Fields(dropStopWords(stripPunctuation(r.Title), DefaultStopWords)) {
+		if w := keyPart(word); w != "" {
+			key += w
+			break
+		}
+	}
+	if key == "" || key == r.Year() {
+		return "eprint" + r.EPrintID
+	}
+	return key
+}
+
+// BibTeX returns the record formatted as a BibTeX entry using the citation key provided
+func (r *Record) BibTeX(key string) string {
+	entryType, ok := bibTeXTypes[r.Type]
+	if ok == false {
+		entryType = "misc"
+	}
+	fields := [][]string{
+		{"author", r.Authors(" and ")},
+		{"title", r.Title},
+		{"year", r.Year()},
+	}
+	switch entryType {
+	case "article":
+		fields = append(fields, []string{"journal", r.Publication})
+	case "incollection", "inproceedings":
+		fields = append(fields, []string{"booktitle", r.BookTitle})
+	}
+	fields = append(fields, [][]string{
+		{"volume", r.Volume},
+		{"number", r.Number},
+		{"pages", pageRange(r.PageRange, "--")},
+		{"publisher", r.Publisher},
+		{"doi", r.DOI},
+		{"url", r.URL},
+	}...)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "@%s{%s,\n", entryType, key)
+	for _, field := range fields {
+		if strings.TrimSpace(field[1]) != "" {
+			fmt.Fprintf(&out, "  %s = {%s},\n", field[0], bibTeXEscape.Replace(strings.TrimSpace(field[1])))
+		}
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// isSelected returns true if a selection cell holds a value other than blank, 0, n, no or false
+func isSelected(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "n", "no", "false":
+		return false
+	}
+	return true
+}
+
+// WriteBibTeX fetches the record for each link and writes a BibTeX entry to out. Duplicate
+// links (the same record's export URL) are written once and keys shared by different records
+// get a "b", "c", ... "z", "aa", ... suffix that no other entry's key uses.
+func WriteBibTeX(out io.Writer, links []string, headers map[string]string, println func(string)) error {
+	return writeBibTeX(out, links, func(api *url.URL) ([]byte, error) {
+		return Request(api, headers)
+	}, println)
+}
+
+// keySuffix returns the n'th letter suffix counting a, b, ... z, aa, ab, ...
+func keySuffix(n int) string {
+	s := ""
+	for ; n > 0; n = (n - 1) / 26 {
+		s = string(rune('a'+(n-1)%26)) + s
+	}
+	return s
+}
+
+// uniqueKey returns key, or key with the first suffix from "b" on, that isn't in issued and adds it to issued
+func uniqueKey(key string, issued map[string]bool) string {
+	candidate := key
+	for n := 2; issued[candidate] == true; n++ {
+		candidate = key + keySuffix(n)
+	}
+	issued[candidate] = true
+	return candidate
+}
+
+// writeBibTeX is WriteBibTeX sending the requests with request (e.g. xlq.request)
+func writeBibTeX(out io.Writer, links []string, request func(*url.URL) ([]byte, error), println func(string)) error {
+	var errs []string
+	seen := map[string]bool{}
+	issued := map[string]bool{}
+	for _, link := range links {
+		// The same eprint id in two repositories is two records, see recordKey
+		id, err := recordKey(link)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if seen[id] == true {
+			continue
+		}
+		seen[id] = true
+		record, err := getRecord(link, request)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		key := uniqueKey(CitationKey(record), issued)
+		if _, err := io.WriteString(out, record.BibTeX(key)+"\n"); err != nil {
+			return err
+		}
+		println("Wrote " + key + " for " + link)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// linksFromSheet returns the links found in linkCol, when selectCol is zero or more only rows marked selected are returned
+func linksFromSheet(sheet *xlsx.Sheet, linkCol int, selectCol int, start int) []string {
+	links := []string{}
+	for i := range sheet.Rows {
+		if i < start {
+			continue
+		}
+		if selectCol >= 0 && isSelected(GetCell(sheet, i, selectCol)) == false {
+			continue
+		}
+		// A cell may hold several links, one per line
+		for _, link := range strings.Split(GetCell(sheet, i, linkCol), "\n") {
+			if isLink(strings.TrimSpace(link)) {
+				links = append(links, strings.TrimSpace(link))
+			}
+		}
+	}
+	return links
+}
+
+// BibTeXRunner reads the links in xlq.LinkColumn of xlq.SheetName (optionally only the rows marked in
+// xlq.SelectColumn) and writes a BibTeX entry for each to bibName.
+func BibTeXRunner(xlq *XLQuery, bibName string, println func(string)) error {
+	workbook, err := xlsx.OpenFile(xlq.WorkbookName)
+	if err != nil {
+		return errors.New("Can't open " + xlq.WorkbookName + ", " + err.Error())
+	}
+	sheet, ok := workbook.Sheet[xlq.SheetName]
+	if ok == false {
+		return errors.New("Can't read " + xlq.WorkbookName + "." + xlq.SheetName)
+	}
+	linkIndex, err := ColumnNameToIndex(xlq.LinkColumn)
+	if err != nil {
+		return errors.New("Can't find link column " + xlq.LinkColumn + ", " + err.Error())
+	}
+	selectIndex := -1
+	if xlq.SelectColumn != "" {
+		selectIndex, err = ColumnNameToIndex(xlq.SelectColumn)
+		if err != nil {
+			return errors.New("Can't find select column " + xlq.SelectColumn + ", " + err.Error())
+		}
+	}
+	start := 0
+	if xlq.SkipFirstRow == true {
+		start = 1
+	}
+	links := linksFromSheet(sheet, linkIndex, selectIndex, start)
+	if err := xlq.startJob(); err != nil {
+		return err
+	}
+
+	fp, err := os.Create(bibName)
+	if err != nil {
+		return errors.New("Can't create " + bibName + ", " + err.Error())
+	}
+	defer fp.Close()
+	// Records are fetched at the same pace as searches
+	err = writeBibTeX(fp, links, func(api *url.URL) ([]byte, error) {
+		xlq.wait()
+		return xlq.request(api)
+	}, println)
+	println("Wrote " + bibName + " from " + strconv.Itoa(len(links)) + " links")
+	return err
+}
+
+// BibEntry is a single entry read from a BibTeX file
+type BibEntry struct {
+	Type string
+	Key  string
+	// Fields holds the field values keyed by lower case field name
+	Fields map[string]string
+	// Order lists the field names in the order they appeared
+	Order []string
+}
+
+// bibTeXUnescape reverses the escaping done when writing entries and turns control spaces (\ ) into spaces
+var bibTeXUnescape = strings.NewReplacer(
+	`\textbackslash{}`, `\`,
+	`\&`, `&`,
+	`\%`, `%`,
+	`\$`, `$`,
+	`\#`, `#`,
+	`\_`, `_`,
+	`\{`, `{`,
+	`\}`, `}`,
+	`\ `, ` `,
+)
+
+// bibAccents maps LaTeX accent commands to the Unicode combining marks they stand for
+var bibAccents = map[string]string{
+	"`": "\u0300",
+	"'": "\u0301",
+	"^": "\u0302",
+	"~": "\u0303",
+	"=": "\u0304",
+	"u": "\u0306",
+	".": "\u0307",
+	`"`: "\u0308",
+	"r": "\u030a",
+	"H": "\u030b",
+	"v": "\u030c",
+	"d": "\u0323",
+	"c": "\u0327",
+	"k": "\u0328",
+	"b": "\u0331",
+}
+
+// bibSymbols maps the LaTeX commands for letters outside ASCII to the letters
+var bibSymbols = map[string]string{
+	"ss": "\u00df",
+	"ae": "\u00e6",
+	"AE": "\u00c6",
+	"oe": "\u0153",
+	"OE": "\u0152",
+	"aa": "\u00e5",
+	"AA": "\u00c5",
+	"o":  "\u00f8",
+	"O":  "\u00d8",
+	"l":  "\u0142",
+	"L":  "\u0141",
+	"i":  "\u0131",
+	"j":  "\u0237",
+}
+
+var (
+	// bibAccent matches an accent command and the letter it applies to, e.g. \"o, \"{o}, \c{c} or \v s,
+	// the letter accents (\c, \v, ...) need a brace or a space before their letter
+	bibAccent = regexp.MustCompile(`\\(?:([\x60'^"~=.])\s*\{?|([urHvdckb])(?:\s+|\s*\{))\s*(\\[ij]|[A-Za-z])\s*\}?`)
+	// bibSymbol matches a letter command, e.g. \ss{}, \o or \AE
+	bibSymbol = regexp.MustCompile(`\\(ss|ae|AE|oe|OE|aa|AA|o|O|l|L|i|j)(?:\{\}|\s+|\b)`)
+)
+
+// bibToUnicode replaces LaTeX accent and letter commands with the Unicode characters they produce
+func bibToUnicode(s string) string {
+	s = bibAccent.ReplaceAllStringFunc(s, func(m string) string {
+		parts := bibAccent.FindStringSubmatch(m)
+		accent := parts[1]
+		if accent == "" {
+			accent = parts[2]
+		}
+		letter := strings.TrimPrefix(parts[3], `\`)
+		return norm.NFC.String(letter + bibAccents[accent])
+	})
+	return bibSymbol.ReplaceAllStringFunc(s, func(m string) string {
+		return bibSymbols[bibSymbol.FindStringSubmatch(m)[1]]
+	})
+}
+
+// bibMonths are the month macros BibTeX styles define
+var bibMonths = map[string]string{
+	"jan": "January",
+	"feb": "February",
+	"mar": "March",
+	"apr": "April",
+	"may": "May",
+	"jun": "June",
+	"jul": "July",
+	"aug": "August",
+	"sep": "September",
+	"oct": "October",
+	"nov": "November",
+	"dec": "December",
+}
+
+// bibParser walks the text of a BibTeX file
+type bibParser struct {
+	src []rune
+	pos int
+	// macros holds the @string definitions read so far keyed by lower case name
+	macros map[string]string
+}
+
+func (p *bibParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// readName reads an entry type, key or field name
+func (p *bibParser) readName() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && strings.ContainsRune(",={}()\"#", p.src[p.pos]) == false && unicode.IsSpace(p.src[p.pos]) == false {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// readBraced reads from an opening brace to its matching close brace, returning the text between them
+func (p *bibParser) readBraced() (string, error) {
+	depth, start := 0, p.pos+1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			// skip escaped characters such as \{ and \}
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+	return "", errors.New("unbalanced braces starting at character " + strconv.Itoa(start))
+}
+
+// readValue reads a field value, either {braced}, "quoted" or a bare number/macro, joined by #
+func (p *bibParser) readValue() (string, error) {
+	parts := []string{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return "", errors.New("unexpected end of file in field value")
+		}
+		switch p.src[p.pos] {
+		case '{':
+			s, err := p.readBraced()
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		case '"':
+			p.pos++
+			start, depth := p.pos, 0
+			for ; p.pos < len(p.src) && (p.src[p.pos] != '"' || depth > 0); p.pos++ {
+				switch p.src[p.pos] {
+				case '\\':
+					p.pos++
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if p.pos >= len(p.src) {
+				return "", errors.New("unterminated quoted value starting at character " + strconv.Itoa(start))
+			}
+			parts = append(parts, string(p.src[start:p.pos]))
+			p.pos++
+		default:
+			// A bare value is a number or the name of an @string macro
+			name := p.readName()
+			if val, ok := p.macros[strings.ToLower(name)]; ok == true {
+				parts = append(parts, val)
+			} else if name == "" || isDigits(name) == true {
+				parts = append(parts, name)
+			} else {
+				return "", errors.New("undefined @string macro " + name)
+			}
+		}
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '#' {
+			p.pos++
+			continue
+		}
+		return strings.Join(parts, ""), nil
+	}
+}
+
+// readMacro reads the name = value of an @string block, from its opening brace or parenthesis, into p.macros
+func (p *bibParser) readMacro() error {
+	p.pos++
+	name := strings.ToLower(p.readName())
+	p.skipSpace()
+	if name == "" || p.pos >= len(p.src) || p.src[p.pos] != '=' {
+		return errors.New("expected a name and = in @string")
+	}
+	p.pos++
+	val, err := p.readValue()
+	if err != nil {
+		return errors.New(err.Error() + " in @string " + name)
+	}
+	p.skipSpace()
+	if p.pos >= len(p.src) || (p.src[p.pos] != '}' && p.src[p.pos] != ')') {
+		return errors.New("expected the end of @string " + name)
+	}
+	p.pos++
+	p.macros[name] = val
+	return nil
+}
+
+// pageDashes matches the dash between pages, e.g. "-" in EPrints, "--" in BibTeX or an en dash
+var pageDashes = regexp.MustCompile(`\s*[-\x{2013}\x{2014}]+\s*`)
+
+// pageRange writes the dashes of a page range (e.g. 1-20, 1--20 or 1–20) as sep, "--" for BibTeX
+// and "-" for a sheet
+func pageRange(s string, sep string) string {
+	return pageDashes.ReplaceAllString(strings.TrimSpace(s), sep)
+}
+
+// cleanBibValue turns accent commands into Unicode, removes protective braces, undoes escaping
+// and collapses whitespace
+func cleanBibValue(s string) string {
+	s = bibTeXUnescape.Replace(bibToUnicode(s))
+	s = strings.Map(func(r rune) rune {
+		if r == '{' || r == '}' {
+			return -1
+		}
+		return r
+	}, s)
+	return norm.NFC.String(strings.Join(strings.Fields(s), " "))
+}
+
+// ParseBibTeX returns the entries in a BibTeX file. @comment and @preamble blocks are skipped,
+// @string macros (and the month abbreviations jan to dec) are expanded where entries use them
+// and text outside of entries is ignored.
+func ParseBibTeX(buf []byte) ([]*BibEntry, error) {
+	p := &bibParser{src: []rune(string(buf)), macros: map[string]string{}}
+	for name, val := range bibMonths {
+		p.macros[name] = val
+	}
+	entries := []*BibEntry{}
+	for {
+		for p.pos < len(p.src) && p.src[p.pos] != '@' {
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			break
+		}
+		p.pos++
+		entryType := strings.ToLower(p.readName())
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '{' && p.src[p.pos] != '(') {
+			continue
+		}
+		if entryType == "string" {
+			if err := p.readMacro(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if entryType == "comment" || entryType == "preamble" {
+			if p.src[p.pos] == '(' {
+				for p.pos < len(p.src) && p.src[p.pos] != ')' {
+					p.pos++
+				}
+			} else if _, err := p.readBraced(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p.pos++
+		entry := &BibEntry{Type: entryType, Key: p.readName(), Fields: map[string]string{}}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return nil, errors.New("unexpected end of file in entry " + entry.Key)
+			}
+			if p.src[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.src[p.pos] == '}' || p.src[p.pos] == ')' {
+				p.pos++
+				break
+			}
+			name := strings.ToLower(p.readName())
+			p.skipSpace()
+			if name == "" || p.pos >= len(p.src) || p.src[p.pos] != '=' {
+				return nil, errors.New("expected a field name and = in entry " + entry.Key)
+			}
+			p.pos++
+			val, err := p.readValue()
+			if err != nil {
+				return nil, errors.New(err.Error() + " in entry " + entry.Key)
+			}
+			if _, ok := entry.Fields[name]; ok == false {
+				entry.Order = append(entry.Order, name)
+			}
+			entry.Fields[name] = cleanBibValue(val)
+			if name == "pages" {
+				entry.Fields[name] = pageRange(entry.Fields[name], "-")
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// BibFieldNames returns the union of field names used by the entries in the order first seen
+func BibFieldNames(entries []*BibEntry) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, entry := range entries {
+		for _, name := range entry.Order {
+			if seen[name] == false {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// BibTeXToSheet writes the entries into sheet, one row per entry with a header row of
+// "key", "type" and then a column for each field used by any entry.
+func BibTeXToSheet(sheet *xlsx.Sheet, entries []*BibEntry) error {
+	header := append([]string{"key", "type"}, BibFieldNames(entries)...)
+	if err := writeRow(sheet, 0, header); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		values := []string{entry.Key, entry.Type}
+		for _, name := range header[2:] {
+			values = append(values, entry.Fields[name])
+		}
+		if err := writeRow(sheet, i+1, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bib2XLRunner reads bibName and writes its entries to xlq.SheetName of xlq.WorkbookName. The workbook
+// is created if it doesn't exist. An existing sheet is only replaced if xlq.OverwriteResult is true.
+func Bib2XLRunner(xlq *XLQuery, bibName string, println func(string)) error {
+	buf, err := ioutil.ReadFile(bibName)
+	if err != nil {
+		return errors.New("Can't read " + bibName + ", " + err.Error())
+	}
+	entries, err := ParseBibTeX(buf)
+	if err != nil {
+		return errors.New("Can't parse " + bibName + ", " + err.Error())
+	}
+
+	var workbook *xlsx.File
+	if _, err := os.Stat(xlq.WorkbookName); os.IsNotExist(err) {
+		workbook = xlsx.NewFile()
+	} else {
+		workbook, err = xlsx.OpenFile(xlq.WorkbookName)
+		if err != nil {
+			return errors.New("Can't open " + xlq.WorkbookName + ", " + err.Error())
+		}
+	}
+	sheet, ok := workbook.Sheet[xlq.SheetName]
+	if ok == true && xlq.OverwriteResult == false {
+		return errors.New(xlq.WorkbookName + "." + xlq.SheetName + " already exists")
+	}
+	if ok == true {
+		// Clear the old rows so a shorter bibliography doesn't leave stale entries behind
+		sheet.Rows = []*xlsx.Row{}
+		sheet.MaxRow = 0
+		sheet.MaxCol = 0
+	} else {
+		sheet, err = workbook.AddSheet(xlq.SheetName)
+		if err != nil {
+			return errors.New("Can't create " + xlq.WorkbookName + "." + xlq.SheetName + ", " + err.Error())
+		}
+	}
+	if err := BibTeXToSheet(sheet, entries); err != nil {
+		return errors.New("Can't update " + xlq.WorkbookName + "." + xlq.SheetName + ", " + err.Error())
+	}
+	if err := workbook.Save(xlq.WorkbookName); err != nil {
+		return errors.New("Can't save " + xlq.WorkbookName + ", " + err.Error())
+	}
+	println("Wrote " + strconv.Itoa(len(entries)) + " entries from " + bibName + " to " + xlq.WorkbookName + "." + xlq.SheetName)
+	return nil
+}
@@ -0,0 +1,304 @@
+//
+// cells.go - finds sheets by name and cells by A1 reference and describes what a cell holds.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// CellFormatPlain writes each cell's details as an indented list
+	CellFormatPlain = "plain"
+	// CellFormatCSV writes a row per cell
+	CellFormatCSV = "csv"
+	// CellFormatJSON writes the cells as a JSON array
+	CellFormatJSON = "json"
+)
+
+// SheetByName returns the sheet called name. When no sheet has that name a number is taken as
+// the sheet's position in the workbook, 0 being the first.
+func SheetByName(workbook *xlsx.File, name string) (*xlsx.Sheet, error) {
+	if sheet, ok := workbook.Sheet[name]; ok == true {
+		return sheet, nil
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(workbook.Sheets) {
+		return workbook.Sheets[i], nil
+	}
+	names := []string{}
+	for _, sheet := range workbook.Sheets {
+		names = append(names, `"`+sheet.Name+`"`)
+	}
+	return nil, errors.New(`Can't find sheet "` + name + `", the sheets are ` + strings.Join(names, ", "))
+}
+
+// ColumnByName returns the zero-based index of a column named by its letters (e.g. "B") or, when
+// header is true, by the text in its first row (e.g. "Title", ignoring case). Header text is
+// matched first.
+func ColumnByName(sheet *xlsx.Sheet, name string, header bool) (int, error) {
+	if header == true && len(sheet.Rows) > 0 && sheet.Rows[0] != nil {
+		for j := range sheet.Rows[0].Cells {
+			if strings.EqualFold(strings.TrimSpace(GetCell(sheet, 0, j)), strings.TrimSpace(name)) == true {
+				return j, nil
+			}
+		}
+	}
+	return ColumnNameToIndex(name)
+}
+
+// ParseCellRef turns an A1 style reference (e.g. "B3" or "$B$3") into a zero-based row and column
+func ParseCellRef(ref string) (int, int, error) {
+	s := strings.ToUpper(strings.Replace(strings.TrimSpace(ref), "$", "", -1))
+	i := strings.IndexAny(s, "0123456789")
+	if i < 1 {
+		return -1, -1, errors.New(`Can't read cell reference "` + ref + `", expected a column and row like B3`)
+	}
+	col, err := ColumnNameToIndex(s[0:i])
+	if err != nil {
+		return -1, -1, errors.New(`Can't read cell reference "` + ref + `", ` + err.Error())
+	}
+	row, err := strconv.Atoi(s[i:])
+	if err != nil || row < 1 {
+		return -1, -1, errors.New(`Can't read cell reference "` + ref + `", the row should be a number from 1`)
+	}
+	return row - 1, col, nil
+}
+
+// ParseCellRange turns a range like "A1:C5" (or a single reference) into the zero-based first
+// and last rows and columns, the corners may be given in either order
+func ParseCellRange(ref string) (int, int, int, int, error) {
+	corners := strings.SplitN(ref, ":", 2)
+	row1, col1, err := ParseCellRef(corners[0])
+	if err != nil {
+		return -1, -1, -1, -1, err
+	}
+	row2, col2 := row1, col1
+	if len(corners) == 2 {
+		row2, col2, err = ParseCellRef(corners[1])
+		if err != nil {
+			return -1, -1, -1, -1, err
+		}
+	}
+	if row2 < row1 {
+		row1, row2 = row2, row1
+	}
+	if col2 < col1 {
+		col1, col2 = col2, col1
+	}
+	return row1, col1, row2, col2, nil
+}
+
+// CellStyle is the part of a cell's style that changes how it reads in Excel
+type CellStyle struct {
+	Font       string `json:"font,omitempty"`
+	Size       int    `json:"size,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Bold       bool   `json:"bold,omitempty"`
+	Italic     bool   `json:"italic,omitempty"`
+	Underline  bool   `json:"underline,omitempty"`
+	Fill       string `json:"fill,omitempty"`
+	Horizontal string `json:"horizontal,omitempty"`
+	Vertical   string `json:"vertical,omitempty"`
+	WrapText   bool   `json:"wrap_text,omitempty"`
+}
+
+// String describes the style in a few words, e.g. "Calibri 11, bold, fill FFD9D9D9"
+func (s *CellStyle) String() string {
+	parts := []string{}
+	if s.Font != "" {
+		parts = append(parts, strings.TrimSpace(s.Font+" "+strconv.Itoa(s.Size)))
+	}
+	if s.Color != "" {
+		parts = append(parts, "color "+s.Color)
+	}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{s.Bold, "bold"}, {s.Italic, "italic"}, {s.Underline, "underline"}, {s.WrapText, "wrap text"}} {
+		if flag.set == true {
+			parts = append(parts, flag.name)
+		}
+	}
+	if s.Fill != "" {
+		parts = append(parts, "fill "+s.Fill)
+	}
+	if s.Horizontal != "" || s.Vertical != "" {
+		parts = append(parts, "align "+strings.TrimSpace(s.Horizontal+" "+s.Vertical))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CellInfo describes a cell, Row and Column are zero-based as used by GetCell
+type CellInfo struct {
+	Sheet        string     `json:"sheet"`
+	Ref          string     `json:"cell"`
+	Row          int        `json:"row"`
+	Column       int        `json:"column"`
+	Value        string     `json:"value"`
+	Formatted    string     `json:"formatted"`
+	Formula      string     `json:"formula,omitempty"`
+	Type         string     `json:"type"`
+	NumberFormat string     `json:"number_format,omitempty"`
+	Style        *CellStyle `json:"style"`
+}
+
+// cellTypeName names the cell's type, a cell holding a formula is "formula" whatever type its result has
+func cellTypeName(cell *xlsx.Cell) string {
+	if cell.Formula() != "" {
+		return "formula"
+	}
+	switch cell.Type() {
+	case xlsx.CellTypeString:
+		return "string"
+	case xlsx.CellTypeStringFormula:
+		return "formula"
+	case xlsx.CellTypeNumeric:
+		return "numeric"
+	case xlsx.CellTypeBool:
+		return "bool"
+	case xlsx.CellTypeInline:
+		return "inline string"
+	case xlsx.CellTypeError:
+		return "error"
+	case xlsx.CellTypeDate:
+		return "date"
+	}
+	return "general"
+}
+
+// InspectCell describes the cell at row and col of sheet, a cell beyond the sheet's data reads as empty
+func InspectCell(sheet *xlsx.Sheet, row int, col int) *CellInfo {
+	info := &CellInfo{
+		Sheet:  sheet.Name,
+		Ref:    xlsx.GetCellIDStringFromCoords(col, row),
+		Row:    row,
+		Column: col,
+		Type:   "empty",
+		Style:  &CellStyle{},
+	}
+	if row < 0 || col < 0 || row >= len(sheet.Rows) || sheet.Rows[row] == nil || col >= len(sheet.Rows[row].Cells) {
+		return info
+	}
+	cell := sheet.Rows[row].Cells[col]
+	info.Value = cell.Value
+	info.Formula = cell.Formula()
+	info.Type = cellTypeName(cell)
+	info.NumberFormat = cell.GetNumberFormat()
+	// A value Excel can't format is shown as it is stored
+	if formatted, err := cell.FormattedValue(); err == nil {
+		info.Formatted = formatted
+	} else {
+		info.Formatted = cell.Value
+	}
+	if style := cell.GetStyle(); style != nil {
+		info.Style = &CellStyle{
+			Font:       style.Font.Name,
+			Size:       style.Font.Size,
+			Color:      style.Font.Color,
+			Bold:       style.Font.Bold,
+			Italic:     style.Font.Italic,
+			Underline:  style.Font.Underline,
+			Horizontal: style.Alignment.Horizontal,
+			Vertical:   style.Alignment.Vertical,
+			WrapText:   style.Alignment.WrapText,
+		}
+		if style.Fill.PatternType != "" && style.Fill.PatternType != "none" {
+			info.Style.Fill = strings.TrimSpace(style.Fill.PatternType + " " + style.Fill.FgColor)
+		}
+	}
+	return info
+}
+
+// InspectRange describes the cells from row1, col1 to row2, col2 of sheet a row at a time. The range
+// stops at the last row and column the sheet uses, so a whole column (e.g. A1:A1048576) is only as long
+// as the sheet, though the first cell is always described.
+func InspectRange(sheet *xlsx.Sheet, row1 int, col1 int, row2 int, col2 int) []*CellInfo {
+	cells := []*CellInfo{}
+	if last := len(sheet.Rows) - 1; row2 > last {
+		row2 = last
+	}
+	if last := sheet.MaxCol - 1; col2 > last {
+		col2 = last
+	}
+	if row2 < row1 {
+		row2 = row1
+	}
+	if col2 < col1 {
+		col2 = col1
+	}
+	for row := row1; row <= row2; row++ {
+		for col := col1; col <= col2; col++ {
+			cells = append(cells, InspectCell(sheet, row, col))
+		}
+	}
+	return cells
+}
+
+// WriteCellInfo writes cells to out as CellFormatPlain, CellFormatCSV or CellFormatJSON.
+// The plain format quotes values so stray spaces and control characters show.
+func WriteCellInfo(out io.Writer, cells []*CellInfo, format string) error {
+	switch format {
+	case CellFormatJSON:
+		src, err := json.MarshalIndent(cells, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", src)
+		return err
+	case CellFormatCSV:
+		w := csv.NewWriter(out)
+		w.Write([]string{"Sheet", "Cell", "Row", "Column", "Value", "Formatted", "Formula", "Type", "Number Format", "Style"})
+		for _, c := range cells {
+			w.Write([]string{c.Sheet, c.Ref, strconv.Itoa(c.Row), strconv.Itoa(c.Column), c.Value, c.Formatted, c.Formula, c.Type, c.NumberFormat, c.Style.String()})
+		}
+		w.Flush()
+		return w.Error()
+	case CellFormatPlain, "":
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for i, c := range cells {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s!%s\t(row %d, column %d)\n", c.Sheet, c.Ref, c.Row, c.Column)
+			fmt.Fprintf(w, "  value\t%q\n", c.Value)
+			fmt.Fprintf(w, "  formatted\t%q\n", c.Formatted)
+			if c.Formula != "" {
+				fmt.Fprintf(w, "  formula\t%s\n", c.Formula)
+			}
+			fmt.Fprintf(w, "  type\t%s\n", c.Type)
+			if c.NumberFormat != "" {
+				fmt.Fprintf(w, "  number format\t%s\n", c.NumberFormat)
+			}
+			if style := c.Style.String(); style != "" {
+				fmt.Fprintf(w, "  style\t%s\n", style)
+			}
+		}
+		return w.Flush()
+	}
+	return errors.New("Cell format should be " + CellFormatPlain + ", " + CellFormatCSV + " or " + CellFormatJSON + ", got " + format)
+}
@@ -0,0 +1,201 @@
+//
+// client.go - the HTTP client shared by the requests of a run, with proxy, TLS and deadline settings.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPClient returns the client used for every request of a run. It is built from ProxyURL, CABundle,
+// ClientCert and ClientKey the first time it is needed then reused so connections to the repository
+// stay open between requests.
+func (xlq *XLQuery) HTTPClient() (*http.Client, error) {
+	if xlq.client != nil {
+		return xlq.client, nil
+	}
+	client := &http.Client{}
+	// The default transport is kept unless it needs changing (GopherJS replaces it with one using fetch)
+	if xlq.ProxyURL != "" || xlq.CABundle != "" || xlq.ClientCert != "" || xlq.ClientKey != "" {
+		transport, err := xlq.transport()
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = transport
+	}
+	xlq.client = client
+	return client, nil
+}
+
+// transport returns an http.Transport using the proxy and TLS settings of xlq
+func (xlq *XLQuery) transport() (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     &tls.Config{},
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if xlq.ProxyURL != "" {
+		proxy, err := parseProxyURL(xlq.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if xlq.CABundle != "" {
+		src, err := ioutil.ReadFile(xlq.CABundle)
+		if err != nil {
+			return nil, errors.New("Can't read CA bundle " + xlq.CABundle + ", " + err.Error())
+		}
+		// The bundle adds to the system's certificate authorities rather than replacing them
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if pool.AppendCertsFromPEM(src) == false {
+			return nil, errors.New("No certificates found in CA bundle " + xlq.CABundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if xlq.ClientCert != "" || xlq.ClientKey != "" {
+		if xlq.ClientCert == "" {
+			return nil, errors.New("A client key needs a client certificate")
+		}
+		// A single PEM file may hold both the certificate and its key
+		keyFile := xlq.ClientKey
+		if keyFile == "" {
+			keyFile = xlq.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(xlq.ClientCert, keyFile)
+		if err != nil {
+			return nil, errors.New("Can't load client certificate " + xlq.ClientCert + ", " + err.Error())
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return transport, nil
+}
+
+// parseProxyURL reads a proxy setting, a bare "host:port" is taken as an HTTP proxy
+func parseProxyURL(s string) (*url.URL, error) {
+	if strings.Contains(s, "://") == false {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.New("Can't parse proxy " + redactURL(s) + ", " + err.Error())
+	}
+	if u.Host == "" {
+		return nil, errors.New("Can't parse proxy " + redactURL(s) + ", no host")
+	}
+	return u, nil
+}
+
+// startJob sets the deadline of a run from JobTimeout and builds the client its requests share
+func (xlq *XLQuery) startJob() error {
+	xlq.deadline = time.Time{}
+	if xlq.JobTimeout > 0 {
+		xlq.deadline = time.Now().Add(xlq.JobTimeout)
+	}
+	_, err := xlq.HTTPClient()
+	return err
+}
+
+// expired reports if the deadline of the run has passed
+func (xlq *XLQuery) expired() bool {
+	return xlq.deadline.IsZero() == false && time.Now().After(xlq.deadline)
+}
+
+// request sends a request using the client and deadline of the run. The headers, and with them the
+// credentials, are only sent to the host of EPrintsSearchURL.
+func (xlq *XLQuery) request(api *url.URL) ([]byte, error) {
+	client, err := xlq.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	if repository, err := url.Parse(xlq.EPrintsSearchURL); err == nil && sameHost(api, repository) == true {
+		headers = xlq.RequestHeaders()
+	}
+	return doRequest(client, api, headers, xlq.deadline)
+}
+
+// sameHost reports if a and b name the same host and port
+func sameHost(a *url.URL, b *url.URL) bool {
+	return a.Host != "" && strings.EqualFold(a.Host, b.Host) == true
+}
+
+// doRequest sends a GET request with client, a request still waiting at deadline (unless zero) is cancelled
+func doRequest(client *http.Client, api *url.URL, headers map[string]string, deadline time.Time) ([]byte, error) {
+	req, err := http.NewRequest("GET", api.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for ky, val := range headers {
+		req.Header.Add(ky, val)
+	}
+	// A redirect to another host drops the headers so credentials stay with the host they were meant for,
+	// the copy shares client's transport and its connections
+	c := *client
+	c.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if sameHost(next.URL, api) == false {
+			for ky := range headers {
+				next.Header.Del(ky)
+			}
+		}
+		return nil
+	}
+	client = &c
+	if deadline.IsZero() == false {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error repeats the URL which may hold a password
+		if urlErr, ok := err.(*url.Error); ok == true {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return nil, err
+	}
+	// The body is read to the end and closed so the connection can be reused
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// An error page (e.g. a login form or a 404) isn't a result
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New("HTTP " + strconv.Itoa(resp.StatusCode) + " from " + redactURL(resp.Request.URL.String()))
+	}
+	return body, nil
+}
@@ -1,7 +1,24 @@
+//
+// checkcell - shows what a workbook cell holds, its value, formatted value, formula, type and style.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -10,39 +27,58 @@ import (
 
 	// Caltech Library packages
 	"github.com/caltechlibrary/cli"
+	"github.com/caltechlibrary/excelquery"
 
 	// 3rd Party Go packages
 	"github.com/tealeg/xlsx"
 )
 
 var (
-	usage = `USAGE: %s XLSX_FILENAME SHEET_NO ROW_NO COLUMN_NO`
+	usage = `USAGE: %s [OPTIONS] XLSX_FILENAME SHEET CELL`
 
 	description = `
 
-%s check the contents of a cell
+%s shows what a cell holds as Go reads it: the stored value, the value as
+Excel formats it, the formula, the cell type, number format and style. Values
+are quoted so stray spaces and control characters show.
+
+SHEET is a sheet name or number (0 is the first sheet). CELL is an A1 style
+reference (e.g. B3) or a range (e.g. A1:C5), ranges stop at the last row and
+column the sheet uses. The older form, a zero-based row and column number
+(e.g. 2 1 for B3), still works.
 `
 
 	examples = `
 EXAMPLE
 
-	%s inventory.xlsx 0 20 20
+	%s titlelist.xlsx "Sheet 1" A2
+
+Show the contents of cell A2 of "Sheet 1" in titlelist.xlsx.
+
+	%s -format csv titlelist.xlsx 0 A1:C20
 
-Show the contents of inventory.xlsx, sheet number 0 (the first sheet) 
-row 20 and column 20.
+Write the first 20 rows of columns A to C of the first sheet as CSV.
 `
 
 	// Standard Options
 	showHelp    bool
 	showLicense bool
 	showVersion bool
+
+	outputFormat = excelquery.CellFormatPlain
 )
 
 func init() {
 	// Standard Options
 	flag.BoolVar(&showHelp, "h", false, "display help")
+	flag.BoolVar(&showHelp, "help", false, "display help")
 	flag.BoolVar(&showLicense, "l", false, "display license")
+	flag.BoolVar(&showLicense, "license", false, "display license")
 	flag.BoolVar(&showVersion, "v", false, "display version")
+	flag.BoolVar(&showVersion, "version", false, "display version")
+
+	// App specific flags
+	flag.StringVar(&outputFormat, "format", outputFormat, "print cells as plain, csv or json")
 }
 
 func main() {
@@ -54,7 +90,7 @@ func main() {
 	cfg := cli.New(appName, appName, fmt.Sprintf(excelquery.LicenseText, appName, excelquery.Version), excelquery.Version)
 	cfg.UsageText = fmt.Sprintf(usage, appName)
 	cfg.DescriptionText = fmt.Sprintf(description, appName)
-	cfg.ExampleText = fmt.Sprintf(examples, appName)
+	cfg.ExampleText = fmt.Sprintf(examples, appName, appName)
 
 	if showHelp == true {
 		fmt.Println(cfg.Usage())
@@ -71,49 +107,51 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(args) != 4 {
-		fmt.Fprintf(os.Stderr, "USAGE: t XLSX_FILENAME SHEET_NO ROW_NO COLUMN_NO\n")
+	if len(args) != 3 && len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s XLSX_FILENAME SHEET CELL\n", appName)
 		os.Exit(1)
 	}
-	i := 0
 	fname := args[0]
-	i++
-	sheetNo, err := strconv.Atoi(args[i])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "SHEET_NO %s should be a number, %s\n", args[i], err)
-		os.Exit(1)
-	}
-	i++
-	row, err := strconv.Atoi(args[i])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "row %s should be a number, %s\n", args[i], err)
-		os.Exit(1)
-	}
-	i++
-	column, err := strconv.Atoi(args[i])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "column %s should be a number, %s\n", args[i], err)
-		os.Exit(1)
+
+	var (
+		row1, col1, row2, col2 int
+		err                    error
+	)
+	if len(args) == 4 {
+		// The older form, zero-based row and column numbers
+		row1, err = strconv.Atoi(args[2])
+		if err != nil || row1 < 0 {
+			fmt.Fprintf(os.Stderr, "row %s should be a number from 0\n", args[2])
+			os.Exit(1)
+		}
+		col1, err = strconv.Atoi(args[3])
+		if err != nil || col1 < 0 {
+			fmt.Fprintf(os.Stderr, "column %s should be a number from 0\n", args[3])
+			os.Exit(1)
+		}
+		row2, col2 = row1, col1
+	} else {
+		row1, col1, row2, col2, err = excelquery.ParseCellRange(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 	}
-	i++
 
 	workbook, err := xlsx.OpenFile(fname)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Can't open workbook %s, %s\n", fname, err)
 		os.Exit(1)
 	}
-	if len(workbook.Sheets) <= sheetNo {
-		fmt.Fprintf(os.Stderr, "Can't find sheet %s in workbook %s\n", sheetNo, fname)
+	sheet, err := excelquery.SheetByName(workbook, args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s in %s\n", err, fname)
 		os.Exit(1)
 	}
 
-	sheet := workbook.Sheets[sheetNo]
-
-	// Now write the cell data out!
-	src, err := json.Marshal(sheet.Cell(row, column))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Can't marshal cell, %s\n", err)
+	cells := excelquery.InspectRange(sheet, row1, col1, row2, col2)
+	if err := excelquery.WriteCellInfo(os.Stdout, cells, outputFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("%s\n", src)
 }
@@ -0,0 +1,160 @@
+//
+// excelquery-server - runs excelquery over HTTP so workbooks can be processed without installing the command line tools.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	// Caltech Library packages
+	"github.com/caltechlibrary/cli"
+	"github.com/caltechlibrary/excelquery"
+)
+
+var (
+	usage = `USAGE: %s [OPTIONS]`
+
+	description = `
+
+%s runs excelquery as a web service. Upload a workbook and its settings and
+get back the workbook with a result sheet added.
+
+	POST /run              process the workbook and return it
+	POST /jobs             queue the workbook, returns the job's status as JSON
+	GET  /jobs/ID          the job's status as JSON
+	GET  /jobs/ID/workbook download the processed workbook once the job is done
+	GET  /search?q=TITLE   run a single query, format=json (default), table or text
+
+The workbook is sent as the "workbook" field of a multipart form or as the
+request body. Settings are form fields or query parameters named after the
+XLQuery settings, e.g. SheetName, QueryColumn, ResultSheetName, ResultDataPaths.
+The repository and its credentials are server settings, a request naming
+EPrintsSearchURL or Profile is refused.
+`
+
+	examples = `
+EXAMPLE
+
+	%s -http localhost:8000
+
+	curl -F workbook=@titlelist.xlsx -F QueryColumn=A \
+		-o titlelist-results.xlsx http://localhost:8000/run
+
+Processes titlelist.xlsx, searching the titles in column A of Sheet1.
+`
+
+	// Standard Options
+	showHelp    bool
+	showLicense bool
+	showVersion bool
+
+	eprintsSearchURL = "http://authors.library.caltech.edu/cgi/search/advanced/"
+	httpAddr         = "localhost:8000"
+	workers          = 1
+	maxUpload        = int64(excelquery.DefaultMaxUpload)
+	jobLifetime      = excelquery.DefaultJobLifetime
+	requestDelay     time.Duration
+	jobTimeout       time.Duration
+)
+
+func init() {
+	// General flags
+	flag.BoolVar(&showHelp, "h", false, "show help information")
+	flag.BoolVar(&showHelp, "help", false, "show help information")
+	flag.BoolVar(&showVersion, "v", false, "show version information")
+	flag.BoolVar(&showVersion, "version", false, "show version information")
+	flag.BoolVar(&showLicense, "l", false, "show license information")
+	flag.BoolVar(&showLicense, "license", false, "show license information")
+
+	// App specific flags
+	flag.StringVar(&httpAddr, "http", httpAddr, "the address to listen on")
+	flag.IntVar(&workers, "workers", workers, "how many queued jobs run at once")
+	flag.Int64Var(&maxUpload, "max-upload", maxUpload, "the largest workbook accepted, in bytes")
+	flag.DurationVar(&jobLifetime, "job-lifetime", jobLifetime, "how long finished jobs are kept for download")
+	flag.DurationVar(&requestDelay, "delay", 0, "least time between requests to the repository across all jobs, e.g. 500ms")
+	flag.DurationVar(&jobTimeout, "job-timeout", 0, "stop querying a job's rows once it has run this long, e.g. 30m")
+
+	// Set from environment
+	if val := os.Getenv("EPRINTS_SEARCH_URL"); val != "" {
+		eprintsSearchURL = val
+	}
+}
+
+func main() {
+	appName := path.Base(os.Args[0])
+	flag.Parse()
+
+	// Configuration and command line interation
+	cfg := cli.New(appName, appName, fmt.Sprintf(excelquery.LicenseText, appName, excelquery.Version), excelquery.Version)
+	cfg.UsageText = fmt.Sprintf(usage, appName)
+	cfg.DescriptionText = fmt.Sprintf(description, appName)
+	cfg.ExampleText = fmt.Sprintf(examples, appName)
+
+	if showHelp == true {
+		fmt.Println(cfg.Usage())
+		os.Exit(0)
+	}
+
+	if showLicense == true {
+		fmt.Println(cfg.License())
+		os.Exit(0)
+	}
+
+	if showVersion == true {
+		fmt.Println(cfg.Version())
+		os.Exit(0)
+	}
+
+	xlq := new(excelquery.XLQuery)
+	xlq.Init()
+	xlq.EPrintsSearchURL = eprintsSearchURL
+	xlq.RequestDelay = requestDelay
+	xlq.JobTimeout = jobTimeout
+	// Credentials for protected repositories, e.g. EXCELQUERY_USERNAME and EXCELQUERY_PASSWORD,
+	// and the proxy and TLS settings, e.g. EXCELQUERY_PROXY and EXCELQUERY_CA_BUNDLE
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if pair := strings.SplitN(kv, "=", 2); len(pair) == 2 && strings.HasPrefix(pair[0], "EXCELQUERY_") {
+			env[pair[0]] = pair[1]
+		}
+	}
+	if err := xlq.ApplyEnv(env); err != nil {
+		log.Fatal(err)
+	}
+
+	// Jobs copy the defaults so building the client now lets them share its connections
+	if _, err := xlq.HTTPClient(); err != nil {
+		log.Fatal(err)
+	}
+
+	srv := excelquery.NewServer(xlq, workers)
+	srv.MaxUpload = maxUpload
+	srv.JobLifetime = jobLifetime
+
+	log.Printf("%s %s listening on http://%s", appName, excelquery.Version, httpAddr)
+	if err := http.ListenAndServe(httpAddr, srv); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	// Caltech Library packages
 	"github.com/caltechlibrary/cli"
@@ -30,20 +32,50 @@ import (
 )
 
 var (
-	usage = `USAGE: %s XLSX_FILENAME SHEET_NO ROW_NO COLUMN_NO`
+	usage = `USAGE: %s [OPTIONS] XLSX_FILENAME [SHEET_NAME [QUERY_COLUMN [RESULT_SHEET_NAME]]]
+       %s search [OPTIONS] QUERY
+       %s rerun XLSX_FILENAME
+       %s sheets [OPTIONS] XLSX_FILENAME
+       %s lint [OPTIONS] XLSX_FILENAME [SHEET_NAME [QUERY_COLUMN]]`
 
 	description = `
 
-%s query our repositories for matching information.
+%s query our repositories for matching information. The sheet and query column
+default to Sheet1 and A unless a config file, the EXCELQUERY_* environment or
+the arguments give them. The search subcommand runs
+a single query and prints the hits as a table, JSON (-format json) or the first
+hit's link (-format text). With -run-info a run records its settings in a "Run Info"
+sheet, the rerun subcommand repeats the run recorded in a workbook. The sheets
+subcommand (or inspect) lists each sheet with its size, header row and sample
+values of each column to help choose the query sheet and column, the query column
+may be given by its header (e.g. Title) as well as its letter. The lint
+subcommand checks the query column without sending any requests, reporting
+empty cells, formulas without a saved value, hidden or special characters,
+numbers, very short or long queries and duplicates.
 `
 
 	examples = `
 EXAMPLE
 
-	%s inventory.xlsx 0 20 20
+	%s titlelist.xlsx "Sheet 1" A
 
-Query sheet number 0 (the first sheet) based on row 20,
-column 20.
+Query the titles in column A of "Sheet 1" writing the hits to a "Result" sheet.
+
+	%s search -format json "Gravitational Waves in a Shallow Compressible Liquid"
+
+Print the hits for a single title as JSON.
+
+	%s rerun titlelist.xlsx
+
+Repeat the run recorded in titlelist.xlsx's "Run Info" sheet.
+
+	%s sheets titlelist.xlsx
+
+List the sheets of titlelist.xlsx and what each column holds.
+
+	%s lint -lint-sheet titlelist.xlsx "Sheet 1" A
+
+Check the titles in column A before a run, also writing the report to a "Lint" sheet.
 `
 
 	// Standard Options
@@ -53,10 +85,88 @@ column 20.
 
 	eprintsSearchURL = "http://authors.library.caltech.edu/cgi/search/advanced/"
 	sheetName        = "Sheet1"
+	queryColumn      = "A"
 	resultSheetName  = "Result"
 	skipFirstRow     = true
+	statusColumn     string
+	hitCountColumn   string
+	minHits          = 1
+	manyHits         = excelquery.DefaultManyHitsThreshold
+	scoreMatches     bool
+	minScore         float64
+	bestLinkColumn   string
+	bestTitleColumn  string
+	bestMatchMargin  = excelquery.DefaultBestMatchMargin
+	normalizeSteps   string
+	maxQueryLength   int
+	dedupQueries     = true
+	hyperlinks       = true
+	descriptionNotes int
+	styleResults     bool
+	lowScore         = excelquery.DefaultLowScore
+	enrichHits       bool
+	requestDelay     time.Duration
+	lookupMode       bool
+	doiSearchField   = "doi"
+	resultPaths      string
+	compoundOutput   = excelquery.CompoundRows
+	compoundSep      = excelquery.DefaultCompoundSeparator
+	resultLayout     = excelquery.LayoutLong
+	wideHits         = excelquery.DefaultWideHits
+	outlineHits      bool
+	maxHits          int
+	sortOrder        string
+	searchOrder      string
+	maxPages         = excelquery.DefaultMaxPages
+	offsetParam      = excelquery.DefaultOffsetParameter
+	searchFormat     = excelquery.SearchFormatTable
+	searchField      string
+	sampleValues     = excelquery.DefaultSampleValues
+	lintSheet        bool
+	lintMinLength    = excelquery.DefaultLintMinLength
+	lintMaxLength    = excelquery.DefaultLintMaxLength
+	configFile       string
+	profileName      string
+	queryParam       = "title"
+	searchParams     string
+	runInfo          = false
+	username         string
+	headers          headerList
+	proxyURL         string
+	caBundle         string
+	clientCert       string
+	clientKey        string
+	jobTimeout       time.Duration
 )
 
+// headerList collects repeated -header "Name: value" options
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(val string) error {
+	if strings.Contains(val, ":") == false {
+		return fmt.Errorf("expected Name: value, got %q", val)
+	}
+	*h = append(*h, val)
+	return nil
+}
+
+// environment returns the EXCELQUERY_* environment variables, e.g. EXCELQUERY_PASSWORD
+func environment() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "EXCELQUERY_") {
+			if pair := strings.SplitN(kv, "=", 2); len(pair) == 2 {
+				env[pair[0]] = pair[1]
+			}
+		}
+	}
+	return env
+}
+
 func init() {
 	// General flags
 	flag.BoolVar(&showHelp, "h", false, "show help information")
@@ -69,6 +179,55 @@ func init() {
 	// App specific flags
 	flag.BoolVar(&skipFirstRow, "s", skipFirstRow, "set boolean for skipping first row of sheet (default true)")
 	flag.BoolVar(&skipFirstRow, "skip", skipFirstRow, "set boolean for skipping first row of spreadsheet (default true)")
+	flag.StringVar(&statusColumn, "status-column", "", "write OK, NO_HITS, MANY_HITS or ERROR for each row into this column of the query sheet")
+	flag.StringVar(&hitCountColumn, "count-column", "", "write the number of hits for each row into this column of the query sheet")
+	flag.IntVar(&minHits, "min-hits", minHits, "rows with fewer hits than this are marked NO_HITS")
+	flag.IntVar(&manyHits, "many-hits", manyHits, "rows with more hits than this are marked MANY_HITS")
+	flag.BoolVar(&scoreMatches, "score", false, "score each hit's title against the query and sort results by score")
+	flag.Float64Var(&minScore, "min-score", 0.0, "with -score, drop hits scoring below this value (0.0 to 1.0)")
+	flag.StringVar(&bestLinkColumn, "best-link-column", "", "with -score, write the link of a clear best match into this column of the query sheet")
+	flag.StringVar(&bestTitleColumn, "best-title-column", "", "with -score, write the title of a clear best match into this column of the query sheet")
+	flag.Float64Var(&bestMatchMargin, "best-margin", bestMatchMargin, "how far ahead of the runner up a best match must score")
+	flag.StringVar(&normalizeSteps, "normalize", "", "comma separated normalize steps to apply to queries (e.g. entities,quotes,fold,subtitle,punctuation,stopwords,trim), use 'default' for "+strings.Join(excelquery.DefaultNormalizeSteps, ","))
+	flag.IntVar(&maxQueryLength, "max-query-length", 0, "truncate queries to this many characters (0 means no limit)")
+	flag.BoolVar(&dedupQueries, "dedup", dedupQueries, "send identical queries once and share the results with each row (default true)")
+	flag.BoolVar(&hyperlinks, "hyperlinks", hyperlinks, "write links as clickable hyperlinks (default true)")
+	flag.BoolVar(&styleResults, "style", false, "format the result sheet for review and highlight rows with no hits, many hits or low scores")
+	flag.Float64Var(&lowScore, "low-score", lowScore, "with -style and -score, highlight hits scoring below this value")
+	flag.BoolVar(&enrichHits, "enrich", false, "fetch the full record for each hit adding authors, date, type, publication, DOI and eprint id columns")
+	flag.DurationVar(&requestDelay, "delay", 0, "least time to wait between requests to the repository (e.g. 500ms)")
+	flag.BoolVar(&lookupMode, "lookup", false, "treat the query column as eprint ids, DOIs or repository URLs and fetch each record")
+	flag.StringVar(&doiSearchField, "doi-field", doiSearchField, "with -lookup, the advanced search field used to find a DOI")
+	flag.StringVar(&resultPaths, "results", "", "comma separated result paths, braces group paths into a compound expression (e.g. '{.item[].link, .item[].title},.item[].guid')")
+	flag.StringVar(&compoundOutput, "compound-output", compoundOutput, "write one row per hit ('rows') or one row per query with hits formatted in a cell per expression ('cell', not allowed with -layout wide or -outline)")
+	flag.StringVar(&compoundSep, "compound-separator", compoundSep, "with -compound-output cell, separates a hit's values")
+	flag.StringVar(&resultLayout, "layout", resultLayout, "result sheet layout, 'long' (one row per hit) or 'wide' (one row per query, hits in columns)")
+	flag.IntVar(&wideHits, "wide-hits", wideHits, "with -layout wide, how many hits get columns")
+	flag.BoolVar(&outlineHits, "outline", false, "with -layout long, add a row for each query and group its hits beneath it (not allowed with -layout wide)")
+	flag.IntVar(&maxHits, "max-hits", 0, "keep at most this many hits per query, 0 keeps them all")
+	flag.StringVar(&sortOrder, "sort", "", "sort hits by relevance, date or score")
+	flag.StringVar(&searchOrder, "search-order", "", "EPrints search order, e.g. -date/creators_name/title")
+	flag.IntVar(&maxPages, "max-pages", maxPages, "read up to this many pages of search results per query, 0 reads them all")
+	flag.StringVar(&offsetParam, "offset-param", offsetParam, "search parameter holding the offset of a results page")
+	flag.StringVar(&searchFormat, "format", searchFormat, "with search, print hits as table, json or text, with sheets and lint as table or json")
+	flag.IntVar(&sampleValues, "samples", sampleValues, "with sheets, how many values of each column to show")
+	flag.BoolVar(&lintSheet, "lint-sheet", false, "with lint, also write the report to a \"Lint\" sheet of the workbook")
+	flag.IntVar(&lintMinLength, "min-length", lintMinLength, "with lint, report queries shorter than this many characters")
+	flag.IntVar(&lintMaxLength, "max-length", lintMaxLength, "with lint, report queries longer than this many characters")
+	flag.StringVar(&searchField, "field", "", "with search -format text, the value of the first hit to print (default Link)")
+	flag.StringVar(&configFile, "config", "", "read settings from a JSON job config file or a shell style NAME=VALUE file like etc/setup.conf-example (not TOML or YAML)")
+	flag.StringVar(&profileName, "profile", "", "search a named repository profile, e.g. authors, thesis or data")
+	flag.StringVar(&queryParam, "query-param", queryParam, "the search parameter the query is sent as")
+	flag.StringVar(&searchParams, "params", "", "comma separated NAME=VALUE search parameters sent with every query")
+	flag.StringVar(&username, "user", "", "username for HTTP Basic authentication, the password is read from EXCELQUERY_PASSWORD")
+	flag.Var(&headers, "header", "add a \"Name: value\" header to every request, may be repeated")
+	flag.StringVar(&proxyURL, "proxy", "", "send requests through this HTTP(S) proxy (default from HTTPS_PROXY or HTTP_PROXY)")
+	flag.StringVar(&caBundle, "ca-bundle", "", "PEM file of certificate authorities to trust as well as the system's")
+	flag.StringVar(&clientCert, "client-cert", "", "PEM file of a client certificate to present to the repository")
+	flag.StringVar(&clientKey, "client-key", "", "PEM file of the client certificate's key (default the -client-cert file)")
+	flag.DurationVar(&jobTimeout, "job-timeout", 0, "stop querying rows once the run has taken this long (e.g. 30m)")
+	flag.BoolVar(&runInfo, "run-info", runInfo, "record the run's settings and counts in a \"Run Info\" sheet so it can be rerun")
+	flag.IntVar(&descriptionNotes, "description-notes", 0, "move descriptions longer than this many characters to a Notes sheet (0 keeps them in the result sheet)")
 
 	// Set from environment
 	if val := os.Getenv("EPRINTS_SEARCH_URL"); val != "" {
@@ -76,15 +235,193 @@ func init() {
 	}
 }
 
+// applyFlag copies the value of a command line option into xlq
+func applyFlag(xlq *excelquery.XLQuery, name string) {
+	switch name {
+	case "s", "skip":
+		xlq.SkipFirstRow = skipFirstRow
+	case "status-column":
+		xlq.StatusColumn = statusColumn
+	case "count-column":
+		xlq.HitCountColumn = hitCountColumn
+	case "min-hits":
+		xlq.MinHitsThreshold = minHits
+	case "many-hits":
+		xlq.ManyHitsThreshold = manyHits
+	case "score":
+		xlq.ScoreMatches = scoreMatches
+	case "min-score":
+		xlq.MinScore = minScore
+	case "best-link-column":
+		xlq.BestLinkColumn = bestLinkColumn
+	case "best-title-column":
+		xlq.BestTitleColumn = bestTitleColumn
+	case "best-margin":
+		xlq.BestMatchMargin = bestMatchMargin
+	case "normalize":
+		if normalizeSteps == "default" {
+			xlq.NormalizeSteps = excelquery.DefaultNormalizeSteps
+		} else if normalizeSteps != "" {
+			xlq.NormalizeSteps = strings.Split(normalizeSteps, ",")
+		}
+	case "max-query-length":
+		xlq.MaxQueryLength = maxQueryLength
+	case "dedup":
+		xlq.DeduplicateQueries = dedupQueries
+	case "hyperlinks":
+		xlq.Hyperlinks = hyperlinks
+	case "description-notes":
+		xlq.DescriptionNoteLength = descriptionNotes
+	case "style":
+		xlq.StyleResults = styleResults
+	case "low-score":
+		xlq.LowScore = lowScore
+	case "enrich":
+		xlq.EnrichHits = enrichHits
+	case "delay":
+		xlq.RequestDelay = requestDelay
+	case "lookup":
+		xlq.LookupMode = lookupMode
+	case "doi-field":
+		xlq.DOISearchField = doiSearchField
+	case "results":
+		if resultPaths != "" {
+			xlq.ResultDataPaths = excelquery.SplitResultExpressions(resultPaths)
+		}
+	case "compound-output":
+		xlq.CompoundOutput = compoundOutput
+	case "compound-separator":
+		xlq.CompoundSeparator = compoundSep
+	case "layout":
+		xlq.ResultLayout = resultLayout
+	case "wide-hits":
+		xlq.WideHits = wideHits
+	case "outline":
+		xlq.OutlineHits = outlineHits
+	case "max-hits":
+		xlq.MaxHits = maxHits
+	case "sort":
+		xlq.SortOrder = sortOrder
+	case "search-order":
+		xlq.SearchOrder = searchOrder
+	case "max-pages":
+		xlq.MaxPages = maxPages
+	case "offset-param":
+		xlq.OffsetParameter = offsetParam
+	case "user":
+		xlq.Username = username
+	case "header":
+		for _, h := range headers {
+			kv := strings.SplitN(h, ":", 2)
+			xlq.Headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	case "proxy":
+		xlq.ProxyURL = proxyURL
+	case "ca-bundle":
+		xlq.CABundle = caBundle
+	case "client-cert":
+		xlq.ClientCert = clientCert
+	case "client-key":
+		xlq.ClientKey = clientKey
+	case "job-timeout":
+		xlq.JobTimeout = jobTimeout
+	case "min-length":
+		xlq.LintMinLength = lintMinLength
+	case "max-length":
+		xlq.LintMaxLength = lintMaxLength
+	case "run-info":
+		xlq.RunInfo = runInfo
+	case "query-param":
+		xlq.QueryParameter = queryParam
+	case "params":
+		for _, pair := range strings.Split(searchParams, ",") {
+			if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+				xlq.SearchParameters[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+}
+
+// newQuery returns an XLQuery with the settings of the config file, then the EXCELQUERY_*
+// environment, then the profile and then the command line options that were given
+func newQuery() (*excelquery.XLQuery, error) {
+	xlq := new(excelquery.XLQuery)
+	xlq.Init()
+	// The workbook has no default, it must come from the config file, environment or arguments
+	xlq.WorkbookName = ""
+	xlq.EPrintsSearchURL = eprintsSearchURL
+	xlq.SheetName = sheetName
+	xlq.QueryColumn = queryColumn
+	xlq.ResultSheetName = resultSheetName
+	xlq.OverwriteResult = true
+	if configFile != "" {
+		if err := xlq.ReadConfig(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := xlq.ApplyEnv(environment()); err != nil {
+		return nil, err
+	}
+	if profileName != "" {
+		if err := xlq.ApplyProfile(profileName); err != nil {
+			return nil, err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		applyFlag(xlq, f.Name)
+	})
+	return xlq, nil
+}
+
+// applyArgs sets the workbook, sheet, query column and result sheet from the positional
+// arguments, they override the config file, environment and profile
+func applyArgs(xlq *excelquery.XLQuery, args []string) {
+	for i, val := range args {
+		switch i {
+		case 0:
+			xlq.WorkbookName = val
+		case 1:
+			xlq.SheetName = val
+		case 2:
+			xlq.QueryColumn = val
+		case 3:
+			xlq.ResultSheetName = val
+		}
+	}
+}
+
+// missingSettings names the settings a workbook run needs that are still empty once the
+// config file, environment, profile, options and arguments have all been applied
+func missingSettings(xlq *excelquery.XLQuery) []string {
+	missing := []string{}
+	if xlq.WorkbookName == "" {
+		missing = append(missing, "XLSX_FILENAME")
+	}
+	if xlq.SheetName == "" {
+		missing = append(missing, "SHEET_NAME")
+	}
+	if xlq.QueryColumn == "" {
+		missing = append(missing, "QUERY_COLUMN")
+	}
+	return missing
+}
+
 func main() {
 	appName := path.Base(os.Args[0])
-	flag.Parse()
+	// search, rerun, sheets and lint are subcommands, their options follow them
+	subcommand := ""
+	if len(os.Args) > 1 && (os.Args[1] == "search" || os.Args[1] == "rerun" || os.Args[1] == "sheets" || os.Args[1] == "inspect" || os.Args[1] == "lint") {
+		subcommand = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	// Configuration and command line interation
 	cfg := cli.New(appName, appName, fmt.Sprintf(excelquery.LicenseText, appName, excelquery.Version), excelquery.Version)
-	cfg.UsageText = fmt.Sprintf(usage, appName)
+	cfg.UsageText = fmt.Sprintf(usage, appName, appName, appName, appName, appName)
 	cfg.DescriptionText = fmt.Sprintf(description, appName)
-	cfg.ExampleText = fmt.Sprintf(examples, appName)
+	cfg.ExampleText = fmt.Sprintf(examples, appName, appName, appName, appName, appName)
 
 	if showHelp == true {
 		fmt.Println(cfg.Usage())
@@ -102,28 +439,87 @@ func main() {
 	}
 
 	args := flag.Args()
-	if len(args) < 3 {
-		fmt.Fprintf(os.Stderr, "USAGE: %s XLXS_FILENAME SHEET_NAME QUERY_COLUMN [RESULT_SHEET_NAME]\n", appName)
+	if subcommand == "search" {
+		if len(args) < 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: %s search [OPTIONS] QUERY\n", appName)
+			os.Exit(1)
+		}
+		xlq, err := newQuery()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		res, err := xlq.Search(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if err := excelquery.WriteSearchResult(os.Stdout, res, searchFormat, searchField); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if subcommand == "rerun" {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: %s rerun XLSX_FILENAME\n", appName)
+			os.Exit(1)
+		}
+		// The Run Info sheet has no credentials, they come from the environment and options
+		xlq, err := newQuery()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		err = excelquery.RerunRunner(xlq, args[0], func(msg string) {
+			fmt.Fprintf(os.Stdout, "%s\n", msg)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if subcommand == "sheets" || subcommand == "inspect" {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: %s sheets [OPTIONS] XLSX_FILENAME\n", appName)
+			os.Exit(1)
+		}
+		xlq := new(excelquery.XLQuery)
+		xlq.Init()
+		xlq.WorkbookName = args[0]
+		xlq.SkipFirstRow = skipFirstRow
+		if err := excelquery.SheetsRunner(xlq, os.Stdout, searchFormat, sampleValues); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	xlq, err := newQuery()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-	resultSheetName = "Result"
-	fname, sheetName, queryColumn := args[0], args[1], args[2]
-	if len(args) >= 4 {
-		resultSheetName = args[3]
+	applyArgs(xlq, args)
+	missing := missingSettings(xlq)
+	if subcommand == "lint" {
+		if len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "USAGE: %s lint [OPTIONS] XLSX_FILENAME [SHEET_NAME [QUERY_COLUMN]], missing %s\n", appName, strings.Join(missing, ", "))
+			os.Exit(1)
+		}
+		if err := excelquery.LintRunner(xlq, os.Stdout, searchFormat, lintSheet); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s XLSX_FILENAME [SHEET_NAME [QUERY_COLUMN [RESULT_SHEET_NAME]]], missing %s\n", appName, strings.Join(missing, ", "))
+		os.Exit(1)
 	}
 
-	fmt.Printf("Workbook name: %s, query sheet %s, query column: %s, result sheet: %s\n", fname, sheetName, queryColumn, resultSheetName)
-	xlq := new(excelquery.XLQuery)
-	xlq.Init()
-	xlq.EPrintsSearchURL = eprintsSearchURL
-	xlq.WorkbookName = fname
-	xlq.SheetName = sheetName
-	xlq.QueryColumn = queryColumn
-	xlq.ResultSheetName = resultSheetName
-	xlq.OverwriteResult = true
-	xlq.SkipFirstRow = skipFirstRow
-
-	err := excelquery.CliRunner(xlq, func(msg string) {
+	fmt.Printf("Workbook name: %s, query sheet %s, query column: %s, result sheet: %s\n", xlq.WorkbookName, xlq.SheetName, xlq.QueryColumn, xlq.ResultSheetName)
+	err = excelquery.CliRunner(xlq, func(msg string) {
 		fmt.Fprintf(os.Stdout, "%s\n", msg)
 	})
 	if err != nil {
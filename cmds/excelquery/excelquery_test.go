@@ -0,0 +1,73 @@
+//
+// excelquery - a package for quering Caltech library API (and others) and integrating results into an Excel Workbook.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	// Caltech Library packages
+	"github.com/caltechlibrary/excelquery"
+)
+
+func TestSettings(t *testing.T) {
+	// A profile and just the workbook use the default sheet and query column
+	profileName = "thesis"
+	defer func() {
+		profileName = ""
+	}()
+	xlq, err := newQuery()
+	if err != nil {
+		t.Errorf("Can't apply settings, %s", err)
+		t.FailNow()
+	}
+	applyArgs(xlq, []string{"book.xlsx"})
+	if missing := missingSettings(xlq); len(missing) > 0 {
+		t.Errorf("expected -profile thesis book.xlsx to be enough, missing %q", missing)
+	}
+	if xlq.EPrintsSearchURL != excelquery.DefaultProfiles["thesis"].EPrintsSearchURL || xlq.SheetName != "Sheet1" || xlq.QueryColumn != "A" {
+		t.Errorf("expected the thesis repository, Sheet1 and A, got %s %s %s", xlq.EPrintsSearchURL, xlq.SheetName, xlq.QueryColumn)
+	}
+
+	// The environment gives the sheet and query column, arguments still override it
+	os.Setenv("EXCELQUERY_SHEET", "Titles")
+	os.Setenv("EXCELQUERY_QUERY_COLUMN", "Title")
+	defer os.Unsetenv("EXCELQUERY_SHEET")
+	defer os.Unsetenv("EXCELQUERY_QUERY_COLUMN")
+	xlq, err = newQuery()
+	if err != nil {
+		t.Errorf("Can't apply settings, %s", err)
+		t.FailNow()
+	}
+	applyArgs(xlq, []string{"book.xlsx"})
+	if missing := missingSettings(xlq); len(missing) > 0 || xlq.SheetName != "Titles" || xlq.QueryColumn != "Title" {
+		t.Errorf("expected the environment's sheet and column, got %q %q missing %q", xlq.SheetName, xlq.QueryColumn, missing)
+	}
+	applyArgs(xlq, []string{"book.xlsx", "Sheet2", "C"})
+	if xlq.SheetName != "Sheet2" || xlq.QueryColumn != "C" {
+		t.Errorf("expected the arguments to override the environment, got %q %q", xlq.SheetName, xlq.QueryColumn)
+	}
+
+	// Without a workbook the run can't start
+	xlq, _ = newQuery()
+	if missing := missingSettings(xlq); strings.Join(missing, ",") != "XLSX_FILENAME" {
+		t.Errorf("expected only the workbook missing, got %q", missing)
+	}
+}
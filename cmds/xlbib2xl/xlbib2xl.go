@@ -0,0 +1,120 @@
+//
+// xlbib2xl - turns a BibTeX file into a worksheet with one column per field.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	// Caltech Library packages
+	"github.com/caltechlibrary/cli"
+	"github.com/caltechlibrary/excelquery"
+)
+
+var (
+	usage = `USAGE: %s [OPTIONS] BIB_FILENAME XLSX_FILENAME [SHEET_NAME]`
+
+	description = `
+
+%s reads the entries in BIB_FILENAME and writes them to SHEET_NAME (default "BibTeX")
+of XLSX_FILENAME, one row per entry. The columns are the citation key, the entry type
+and then every field used by any entry. The workbook is created if it doesn't exist.
+`
+
+	examples = `
+EXAMPLE
+
+	%s titles.bib titlelist.xlsx
+
+Adds a sheet named "BibTeX" to titlelist.xlsx holding the entries from titles.bib.
+The title column can then be used as the query column for excelquery.
+`
+
+	// Standard Options
+	showHelp    bool
+	showLicense bool
+	showVersion bool
+
+	overwrite bool
+)
+
+func init() {
+	// General flags
+	flag.BoolVar(&showHelp, "h", false, "show help information")
+	flag.BoolVar(&showHelp, "help", false, "show help information")
+	flag.BoolVar(&showVersion, "v", false, "show version information")
+	flag.BoolVar(&showVersion, "version", false, "show version information")
+	flag.BoolVar(&showLicense, "l", false, "show license information")
+	flag.BoolVar(&showLicense, "license", false, "show license information")
+
+	// App specific flags
+	flag.BoolVar(&overwrite, "o", false, "replace the sheet if it already exists")
+	flag.BoolVar(&overwrite, "overwrite", false, "replace the sheet if it already exists")
+}
+
+func main() {
+	appName := path.Base(os.Args[0])
+	flag.Parse()
+
+	// Configuration and command line interation
+	cfg := cli.New(appName, appName, fmt.Sprintf(excelquery.LicenseText, appName, excelquery.Version), excelquery.Version)
+	cfg.UsageText = fmt.Sprintf(usage, appName)
+	cfg.DescriptionText = fmt.Sprintf(description, appName)
+	cfg.ExampleText = fmt.Sprintf(examples, appName)
+
+	if showHelp == true {
+		fmt.Println(cfg.Usage())
+		os.Exit(0)
+	}
+
+	if showLicense == true {
+		fmt.Println(cfg.License())
+		os.Exit(0)
+	}
+
+	if showVersion == true {
+		fmt.Println(cfg.Version())
+		os.Exit(0)
+	}
+
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s BIB_FILENAME XLSX_FILENAME [SHEET_NAME]\n", appName)
+		os.Exit(1)
+	}
+
+	xlq := new(excelquery.XLQuery)
+	xlq.Init()
+	xlq.WorkbookName = args[1]
+	xlq.SheetName = "BibTeX"
+	if len(args) >= 3 {
+		xlq.SheetName = args[2]
+	}
+	xlq.OverwriteResult = overwrite
+
+	err := excelquery.Bib2XLRunner(xlq, args[0], func(msg string) {
+		fmt.Fprintf(os.Stdout, "%s\n", msg)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,142 @@
+//
+// xlurl2bib - writes a BibTeX file for the repository links in a column of an Excel Workbook.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	// Caltech Library packages
+	"github.com/caltechlibrary/cli"
+	"github.com/caltechlibrary/excelquery"
+)
+
+var (
+	usage = `USAGE: %s [OPTIONS] XLSX_FILENAME SHEET_NAME LINK_COLUMN BIB_FILENAME`
+
+	description = `
+
+%s fetches the repository record for each link in LINK_COLUMN of SHEET_NAME
+and writes a BibTeX entry for it to BIB_FILENAME. Citation keys are built from
+the first author, year and first significant title word (e.g. feynman1959plenty).
+`
+
+	examples = `
+EXAMPLE
+
+	%s -select C titlelist.xlsx "Sheet1" B titles.bib
+
+Write titles.bib with an entry for each link in column B of "Sheet1" where
+column C has been marked (e.g. with an "x").
+
+Records on a protected repository need its credentials, e.g.
+EXCELQUERY_USERNAME and EXCELQUERY_PASSWORD, and EPRINTS_SEARCH_URL set to
+the repository so they are sent to its host. EXCELQUERY_PROXY and
+EXCELQUERY_CA_BUNDLE apply too.
+`
+
+	// Standard Options
+	showHelp    bool
+	showLicense bool
+	showVersion bool
+
+	skipFirstRow = true
+	selectColumn string
+	requestDelay time.Duration
+)
+
+func init() {
+	// General flags
+	flag.BoolVar(&showHelp, "h", false, "show help information")
+	flag.BoolVar(&showHelp, "help", false, "show help information")
+	flag.BoolVar(&showVersion, "v", false, "show version information")
+	flag.BoolVar(&showVersion, "version", false, "show version information")
+	flag.BoolVar(&showLicense, "l", false, "show license information")
+	flag.BoolVar(&showLicense, "license", false, "show license information")
+
+	// App specific flags
+	flag.BoolVar(&skipFirstRow, "s", skipFirstRow, "set boolean for skipping first row of sheet (default true)")
+	flag.BoolVar(&skipFirstRow, "skip", skipFirstRow, "set boolean for skipping first row of spreadsheet (default true)")
+	flag.StringVar(&selectColumn, "select", "", "only export rows with a value (e.g. x) in this column")
+	flag.DurationVar(&requestDelay, "delay", 0, "least time between requests to the repository, e.g. 500ms")
+}
+
+func main() {
+	appName := path.Base(os.Args[0])
+	flag.Parse()
+
+	// Configuration and command line interation
+	cfg := cli.New(appName, appName, fmt.Sprintf(excelquery.LicenseText, appName, excelquery.Version), excelquery.Version)
+	cfg.UsageText = fmt.Sprintf(usage, appName)
+	cfg.DescriptionText = fmt.Sprintf(description, appName)
+	cfg.ExampleText = fmt.Sprintf(examples, appName)
+
+	if showHelp == true {
+		fmt.Println(cfg.Usage())
+		os.Exit(0)
+	}
+
+	if showLicense == true {
+		fmt.Println(cfg.License())
+		os.Exit(0)
+	}
+
+	if showVersion == true {
+		fmt.Println(cfg.Version())
+		os.Exit(0)
+	}
+
+	args := flag.Args()
+	if len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s XLSX_FILENAME SHEET_NAME LINK_COLUMN BIB_FILENAME\n", appName)
+		os.Exit(1)
+	}
+
+	xlq := new(excelquery.XLQuery)
+	xlq.Init()
+	// The repository, credentials and connection settings come from the environment as for excelquery
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if pair := strings.SplitN(kv, "=", 2); len(pair) == 2 && (strings.HasPrefix(pair[0], "EXCELQUERY_") || pair[0] == "EPRINTS_SEARCH_URL") {
+			env[pair[0]] = pair[1]
+		}
+	}
+	if err := xlq.ApplyEnv(env); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	xlq.RequestDelay = requestDelay
+	xlq.WorkbookName = args[0]
+	xlq.SheetName = args[1]
+	xlq.LinkColumn = args[2]
+	xlq.SelectColumn = selectColumn
+	xlq.SkipFirstRow = skipFirstRow
+
+	err := excelquery.BibTeXRunner(xlq, args[3], func(msg string) {
+		fmt.Fprintf(os.Stdout, "%s\n", msg)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
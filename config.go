@@ -0,0 +1,441 @@
+//
+// config.go - job configuration files and named repository profiles.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile describes a repository to search, selected by name with -profile or a config file's "profile"
+type Profile struct {
+	Description      string            `json:"description,omitempty"`
+	EPrintsSearchURL string            `json:"eprints_search_url"`
+	QueryParameter   string            `json:"query_parameter,omitempty"`
+	Parameters       map[string]string `json:"parameters,omitempty"`
+}
+
+// DefaultProfiles are the Caltech Library repositories, config files can add more
+var DefaultProfiles = map[string]*Profile{
+	"authors": &Profile{
+		Description:      "CaltechAUTHORS",
+		EPrintsSearchURL: "http://authors.library.caltech.edu/cgi/search/advanced/",
+	},
+	"thesis": &Profile{
+		Description:      "CaltechTHESIS",
+		EPrintsSearchURL: "http://thesis.library.caltech.edu/cgi/search/advanced/",
+	},
+	"data": &Profile{
+		Description:      "CaltechDATA",
+		EPrintsSearchURL: "http://data.caltech.edu/cgi/search/advanced/",
+	},
+}
+
+// Credentials are the authentication settings of a config file's "auth" block. They are kept
+// apart from JobConfig so they never end up in the Run Info sheet.
+type Credentials struct {
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Cookies     map[string]string `json:"cookies,omitempty"`
+}
+
+// Apply copies the credentials that are set into xlq
+func (c *Credentials) Apply(xlq *XLQuery) {
+	if c.Username != "" {
+		xlq.Username, xlq.Password = c.Username, c.Password
+	}
+	if c.BearerToken != "" {
+		xlq.BearerToken = c.BearerToken
+	}
+	for key, val := range c.Headers {
+		xlq.Headers[key] = val
+	}
+	for key, val := range c.Cookies {
+		xlq.Cookies[key] = val
+	}
+}
+
+// Connection holds the proxy and TLS settings of a config file's "connection" block. Like
+// Credentials they are kept out of JobConfig, they belong to the machine running a job.
+type Connection struct {
+	Proxy      string `json:"proxy,omitempty"`
+	CABundle   string `json:"ca_bundle,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// Apply copies the connection settings that are set into xlq
+func (c *Connection) Apply(xlq *XLQuery) {
+	if c.Proxy != "" {
+		xlq.ProxyURL = c.Proxy
+	}
+	if c.CABundle != "" {
+		xlq.CABundle = c.CABundle
+	}
+	if c.ClientCert != "" {
+		xlq.ClientCert, xlq.ClientKey = c.ClientCert, c.ClientKey
+	}
+}
+
+// JobConfig holds the settings of a run, it is the JSON form of a job config file.
+// Settings missing from a file keep their current values.
+type JobConfig struct {
+	EPrintsSearchURL  string            `json:"eprints_search_url"`
+	Workbook          string            `json:"workbook"`
+	Sheet             string            `json:"sheet"`
+	QueryColumn       string            `json:"query_column"`
+	ResultSheet       string            `json:"result_sheet"`
+	SkipFirstRow      bool              `json:"skip_first_row"`
+	OverwriteResult   bool              `json:"overwrite_result"`
+	QueryParameter    string            `json:"query_parameter"`
+	Parameters        map[string]string `json:"parameters,omitempty"`
+	ResultPaths       []string          `json:"result_paths"`
+	StatusColumn      string            `json:"status_column,omitempty"`
+	HitCountColumn    string            `json:"hit_count_column,omitempty"`
+	MinHits           int               `json:"min_hits"`
+	ManyHits          int               `json:"many_hits"`
+	ScoreMatches      bool              `json:"score_matches"`
+	MinScore          float64           `json:"min_score"`
+	BestLinkColumn    string            `json:"best_link_column,omitempty"`
+	BestTitleColumn   string            `json:"best_title_column,omitempty"`
+	BestMatchMargin   float64           `json:"best_match_margin"`
+	Normalize         []string          `json:"normalize"`
+	MaxQueryLength    int               `json:"max_query_length"`
+	Deduplicate       bool              `json:"deduplicate"`
+	Hyperlinks        bool              `json:"hyperlinks"`
+	DescriptionNotes  int               `json:"description_notes"`
+	StyleResults      bool              `json:"style_results"`
+	LowScore          float64           `json:"low_score"`
+	Enrich            bool              `json:"enrich"`
+	Delay             string            `json:"delay"`
+	Lookup            bool              `json:"lookup"`
+	DOISearchField    string            `json:"doi_search_field"`
+	CompoundOutput    string            `json:"compound_output"`
+	CompoundSeparator string            `json:"compound_separator"`
+	Layout            string            `json:"layout"`
+	WideHits          int               `json:"wide_hits"`
+	Outline           bool              `json:"outline"`
+	MaxHits           int               `json:"max_hits"`
+	Sort              string            `json:"sort,omitempty"`
+	SearchOrder       string            `json:"search_order,omitempty"`
+	MaxPages          int               `json:"max_pages"`
+	OffsetParameter   string            `json:"offset_parameter"`
+	RunInfo           bool              `json:"run_info"`
+	JobTimeout        string            `json:"job_timeout"`
+}
+
+// NewJobConfig returns the settings of xlq as a JobConfig
+func NewJobConfig(xlq *XLQuery) *JobConfig {
+	cfg := &JobConfig{
+		EPrintsSearchURL:  xlq.EPrintsSearchURL,
+		Workbook:          xlq.WorkbookName,
+		Sheet:             xlq.SheetName,
+		QueryColumn:       xlq.QueryColumn,
+		ResultSheet:       xlq.ResultSheetName,
+		SkipFirstRow:      xlq.SkipFirstRow,
+		OverwriteResult:   xlq.OverwriteResult,
+		QueryParameter:    xlq.QueryParameter,
+		Parameters:        map[string]string{},
+		ResultPaths:       append([]string{}, xlq.ResultDataPaths...),
+		StatusColumn:      xlq.StatusColumn,
+		HitCountColumn:    xlq.HitCountColumn,
+		MinHits:           xlq.MinHitsThreshold,
+		ManyHits:          xlq.ManyHitsThreshold,
+		ScoreMatches:      xlq.ScoreMatches,
+		MinScore:          xlq.MinScore,
+		BestLinkColumn:    xlq.BestLinkColumn,
+		BestTitleColumn:   xlq.BestTitleColumn,
+		BestMatchMargin:   xlq.BestMatchMargin,
+		Normalize:         append([]string{}, xlq.NormalizeSteps...),
+		MaxQueryLength:    xlq.MaxQueryLength,
+		Deduplicate:       xlq.DeduplicateQueries,
+		Hyperlinks:        xlq.Hyperlinks,
+		DescriptionNotes:  xlq.DescriptionNoteLength,
+		StyleResults:      xlq.StyleResults,
+		LowScore:          xlq.LowScore,
+		Enrich:            xlq.EnrichHits,
+		Delay:             xlq.RequestDelay.String(),
+		Lookup:            xlq.LookupMode,
+		DOISearchField:    xlq.DOISearchField,
+		CompoundOutput:    xlq.CompoundOutput,
+		CompoundSeparator: xlq.CompoundSeparator,
+		Layout:            xlq.ResultLayout,
+		WideHits:          xlq.WideHits,
+		Outline:           xlq.OutlineHits,
+		MaxHits:           xlq.MaxHits,
+		Sort:              xlq.SortOrder,
+		SearchOrder:       xlq.SearchOrder,
+		MaxPages:          xlq.MaxPages,
+		OffsetParameter:   xlq.OffsetParameter,
+		RunInfo:           xlq.RunInfo,
+		JobTimeout:        xlq.JobTimeout.String(),
+	}
+	for key, val := range xlq.SearchParameters {
+		cfg.Parameters[key] = val
+	}
+	return cfg
+}
+
+// Apply copies the settings into xlq
+func (cfg *JobConfig) Apply(xlq *XLQuery) error {
+	delay, err := time.ParseDuration(cfg.Delay)
+	if err != nil {
+		return errors.New("Can't read delay " + cfg.Delay + ", " + err.Error())
+	}
+	jobTimeout, err := time.ParseDuration(cfg.JobTimeout)
+	if err != nil {
+		return errors.New("Can't read job timeout " + cfg.JobTimeout + ", " + err.Error())
+	}
+	xlq.EPrintsSearchURL = cfg.EPrintsSearchURL
+	xlq.WorkbookName = cfg.Workbook
+	xlq.SheetName = cfg.Sheet
+	xlq.QueryColumn = cfg.QueryColumn
+	xlq.ResultSheetName = cfg.ResultSheet
+	xlq.SkipFirstRow = cfg.SkipFirstRow
+	xlq.OverwriteResult = cfg.OverwriteResult
+	xlq.QueryParameter = cfg.QueryParameter
+	xlq.SearchParameters = map[string]string{}
+	for key, val := range cfg.Parameters {
+		xlq.SearchParameters[key] = val
+	}
+	xlq.ResultDataPaths = append([]string{}, cfg.ResultPaths...)
+	xlq.StatusColumn = cfg.StatusColumn
+	xlq.HitCountColumn = cfg.HitCountColumn
+	xlq.MinHitsThreshold = cfg.MinHits
+	xlq.ManyHitsThreshold = cfg.ManyHits
+	xlq.ScoreMatches = cfg.ScoreMatches
+	xlq.MinScore = cfg.MinScore
+	xlq.BestLinkColumn = cfg.BestLinkColumn
+	xlq.BestTitleColumn = cfg.BestTitleColumn
+	xlq.BestMatchMargin = cfg.BestMatchMargin
+	xlq.NormalizeSteps = append([]string{}, cfg.Normalize...)
+	xlq.MaxQueryLength = cfg.MaxQueryLength
+	xlq.DeduplicateQueries = cfg.Deduplicate
+	xlq.Hyperlinks = cfg.Hyperlinks
+	xlq.DescriptionNoteLength = cfg.DescriptionNotes
+	xlq.StyleResults = cfg.StyleResults
+	xlq.LowScore = cfg.LowScore
+	xlq.EnrichHits = cfg.Enrich
+	xlq.RequestDelay = delay
+	xlq.LookupMode = cfg.Lookup
+	xlq.DOISearchField = cfg.DOISearchField
+	xlq.CompoundOutput = cfg.CompoundOutput
+	xlq.CompoundSeparator = cfg.CompoundSeparator
+	xlq.ResultLayout = cfg.Layout
+	xlq.WideHits = cfg.WideHits
+	xlq.OutlineHits = cfg.Outline
+	xlq.MaxHits = cfg.MaxHits
+	xlq.SortOrder = cfg.Sort
+	xlq.SearchOrder = cfg.SearchOrder
+	xlq.MaxPages = cfg.MaxPages
+	xlq.OffsetParameter = cfg.OffsetParameter
+	xlq.RunInfo = cfg.RunInfo
+	xlq.JobTimeout = jobTimeout
+	return nil
+}
+
+// ApplyProfile sets the repository URL and search parameters from the named profile in xlq.Profiles
+// (DefaultProfiles if xlq wasn't initialized). The search parameters are replaced, not merged, so
+// parameters of an earlier profile or config don't leak into the new repository's searches.
+func (xlq *XLQuery) ApplyProfile(name string) error {
+	if xlq.Profiles == nil {
+		xlq.Profiles = map[string]*Profile{}
+		for key, p := range DefaultProfiles {
+			xlq.Profiles[key] = p
+		}
+	}
+	p, ok := xlq.Profiles[name]
+	if ok == false {
+		names := []string{}
+		for key := range xlq.Profiles {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return errors.New("Unknown profile " + name + ", expected one of " + strings.Join(names, ", "))
+	}
+	xlq.EPrintsSearchURL = p.EPrintsSearchURL
+	if p.QueryParameter != "" {
+		xlq.QueryParameter = p.QueryParameter
+	}
+	xlq.SearchParameters = map[string]string{}
+	for key, val := range p.Parameters {
+		xlq.SearchParameters[key] = val
+	}
+	return nil
+}
+
+// readEnvConfig reads shell style settings (e.g. "export EPRINTS_SEARCH_URL=...") as used by
+// etc/setup.conf-example, blank lines and comments are skipped
+func readEnvConfig(src []byte) (map[string]string, error) {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for i := 1; scanner.Scan() == true; i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		eq := strings.Index(line, "=")
+		if eq < 1 {
+			return nil, errors.New("Expected NAME=VALUE on line " + strconv.Itoa(i) + ", got " + line)
+		}
+		key, val := strings.TrimSpace(line[0:eq]), strings.TrimSpace(line[eq+1:])
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		env[key] = val
+	}
+	return env, scanner.Err()
+}
+
+// ApplyEnv applies shell style settings, EPGO_API_URL (shared with our other EPrints tools)
+// gives the repository, other settings are named EXCELQUERY_*. Credentials come from
+// EXCELQUERY_USERNAME, EXCELQUERY_PASSWORD, EXCELQUERY_TOKEN, EXCELQUERY_COOKIES ("a=1; b=2")
+// and EXCELQUERY_HEADER_* (e.g. EXCELQUERY_HEADER_X_API_KEY sends an X-Api-Key header). The proxy
+// and TLS settings are EXCELQUERY_PROXY, EXCELQUERY_CA_BUNDLE, EXCELQUERY_CLIENT_CERT and
+// EXCELQUERY_CLIENT_KEY.
+func (xlq *XLQuery) ApplyEnv(env map[string]string) error {
+	if val, ok := env["EXCELQUERY_PROFILE"]; ok == true {
+		if err := xlq.ApplyProfile(val); err != nil {
+			return err
+		}
+	}
+	if val, ok := env["EPGO_API_URL"]; ok == true && val != "" {
+		xlq.EPrintsSearchURL = strings.TrimSuffix(val, "/") + "/cgi/search/advanced/"
+	}
+	for key, val := range env {
+		switch key {
+		case "EXCELQUERY_PROFILE", "EPGO_API_URL":
+		case "EPRINTS_SEARCH_URL":
+			xlq.EPrintsSearchURL = val
+		case "EXCELQUERY_WORKBOOK":
+			xlq.WorkbookName = val
+		case "EXCELQUERY_SHEET":
+			xlq.SheetName = val
+		case "EXCELQUERY_QUERY_COLUMN":
+			xlq.QueryColumn = val
+		case "EXCELQUERY_RESULT_SHEET":
+			xlq.ResultSheetName = val
+		case "EXCELQUERY_QUERY_PARAMETER":
+			xlq.QueryParameter = val
+		case "EXCELQUERY_RESULT_PATHS":
+			xlq.ResultDataPaths = SplitResultExpressions(val)
+		case "EXCELQUERY_USERNAME":
+			xlq.Username = val
+		case "EXCELQUERY_PASSWORD":
+			xlq.Password = val
+		case "EXCELQUERY_TOKEN":
+			xlq.BearerToken = val
+		case "EXCELQUERY_PROXY":
+			xlq.ProxyURL = val
+		case "EXCELQUERY_CA_BUNDLE":
+			xlq.CABundle = val
+		case "EXCELQUERY_CLIENT_CERT":
+			xlq.ClientCert = val
+		case "EXCELQUERY_CLIENT_KEY":
+			xlq.ClientKey = val
+		case "EXCELQUERY_JOB_TIMEOUT":
+			jobTimeout, err := time.ParseDuration(val)
+			if err != nil {
+				return errors.New("Can't read " + key + ", " + err.Error())
+			}
+			xlq.JobTimeout = jobTimeout
+		case "EXCELQUERY_COOKIES":
+			for _, pair := range strings.Split(val, ";") {
+				if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+					xlq.Cookies[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+				}
+			}
+		default:
+			if strings.HasPrefix(key, "EXCELQUERY_HEADER_") {
+				name := strings.Replace(strings.TrimPrefix(key, "EXCELQUERY_HEADER_"), "_", "-", -1)
+				xlq.Headers[http.CanonicalHeaderKey(name)] = val
+			} else if strings.HasPrefix(key, "EXCELQUERY_") {
+				return errors.New("Unknown setting " + key)
+			}
+		}
+	}
+	// EPRINTS_SEARCH_URL is more specific than EPGO_API_URL
+	if val, ok := env["EPRINTS_SEARCH_URL"]; ok == true && val != "" {
+		xlq.EPrintsSearchURL = val
+	}
+	return nil
+}
+
+// ReadConfig applies a job config file. JSON files may name a "profile", add "profiles", hold
+// credentials in an "auth" block and proxy and TLS settings in a "connection" block, the profile
+// is applied before the file's other settings. Other files are read as shell style
+// settings like etc/setup.conf-example. TOML and YAML files are refused.
+func (xlq *XLQuery) ReadConfig(fname string) error {
+	switch strings.ToLower(path.Ext(fname)) {
+	case ".toml", ".yaml", ".yml":
+		return errors.New("Can't read " + fname + ", config files are JSON or shell style NAME=VALUE settings, TOML and YAML aren't supported")
+	}
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return errors.New("Can't read " + fname + ", " + err.Error())
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(src), []byte("{")) == false {
+		env, err := readEnvConfig(src)
+		if err != nil {
+			return errors.New("Can't read " + fname + ", " + err.Error())
+		}
+		return xlq.ApplyEnv(env)
+	}
+
+	head := struct {
+		Profile    string              `json:"profile"`
+		Profiles   map[string]*Profile `json:"profiles"`
+		Auth       *Credentials        `json:"auth"`
+		Connection *Connection         `json:"connection"`
+	}{}
+	if err := json.Unmarshal(src, &head); err != nil {
+		return errors.New("Can't read " + fname + ", " + err.Error())
+	}
+	for name, p := range head.Profiles {
+		xlq.Profiles[name] = p
+	}
+	if head.Auth != nil {
+		head.Auth.Apply(xlq)
+	}
+	if head.Connection != nil {
+		head.Connection.Apply(xlq)
+	}
+	if head.Profile != "" {
+		if err := xlq.ApplyProfile(head.Profile); err != nil {
+			return err
+		}
+	}
+	cfg := NewJobConfig(xlq)
+	if err := json.Unmarshal(src, cfg); err != nil {
+		return errors.New("Can't read " + fname + ", " + err.Error())
+	}
+	return cfg.Apply(xlq)
+}
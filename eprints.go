@@ -0,0 +1,358 @@
+//
+// eprints.go - fetches full EPrints records (EP3 XML export) for the links returned by a search.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	// Caltech Library packages
+	"github.com/caltechlibrary/rss2"
+)
+
+// Creator is a person listed as an author of an EPrints record
+type Creator struct {
+	Family string `xml:"name>family" json:"family"`
+	Given  string `xml:"name>given" json:"given"`
+}
+
+// Record holds the bibliographic fields we use from an EPrints EP3 XML record
+type Record struct {
+	URL         string    `xml:"id,attr" json:"url"`
+	EPrintID    string    `xml:"eprintid" json:"eprint_id"`
+	Type        string    `xml:"type" json:"type"`
+	Title       string    `xml:"title" json:"title"`
+	Creators    []Creator `xml:"creators>item" json:"creators"`
+	Date        string    `xml:"date" json:"date"`
+	Publication string    `xml:"publication" json:"publication"`
+	BookTitle   string    `xml:"book_title" json:"book_title"`
+	Volume      string    `xml:"volume" json:"volume"`
+	Number      string    `xml:"number" json:"number"`
+	PageRange   string    `xml:"pagerange" json:"pagerange"`
+	Publisher   string    `xml:"publisher" json:"publisher"`
+	DOI         string    `xml:"doi" json:"doi"`
+	OfficialURL string    `xml:"official_url" json:"official_url"`
+	Abstract    string    `xml:"abstract" json:"abstract"`
+}
+
+// enrichLabels are the result columns added when hits are enriched with their full record
+var enrichLabels = []string{
+	"EPrint ID",
+	"Type",
+	"Authors",
+	"Date",
+	"Publication",
+	"DOI",
+}
+
+// ep3XML is the document returned by the EPrints XML export plugin
+type ep3XML struct {
+	XMLName xml.Name  `xml:"eprints"`
+	Records []*Record `xml:"eprint"`
+}
+
+// Year returns the four digit year from the record's date
+func (r *Record) Year() string {
+	if len(r.Date) >= 4 {
+		return r.Date[0:4]
+	}
+	return ""
+}
+
+// Authors returns the creators as "Family, Given" joined by sep
+func (r *Record) Authors(sep string) string {
+	names := []string{}
+	for _, c := range r.Creators {
+		if c.Given != "" {
+			names = append(names, c.Family+", "+c.Given)
+		} else {
+			names = append(names, c.Family)
+		}
+	}
+	return strings.Join(names, sep)
+}
+
+// EPrintID returns the eprint id from a repository link. Both the short form
+// (e.g. http://authors.library.caltech.edu/12345/) and the long form
+// (e.g. http://authors.library.caltech.edu/id/eprint/12345) are understood.
+func EPrintID(link string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", err
+	}
+	for _, p := range strings.Split(u.Path, "/") {
+		if _, err := strconv.Atoi(p); err == nil && p != "" {
+			return p, nil
+		}
+	}
+	return "", errors.New("Can't find an eprint id in " + link)
+}
+
+// ExportURL returns the EP3 XML export URL for a repository link
+func ExportURL(link string) (*url.URL, error) {
+	id, err := EPrintID(link)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/cgi/export/eprint", id, "XML", "eprint-"+id+".xml")
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, nil
+}
+
+// ParseEP3XML returns the records found in an EPrints XML export
+func ParseEP3XML(buf []byte) ([]*Record, error) {
+	doc := new(ep3XML)
+	if err := xml.Unmarshal(buf, doc); err != nil {
+		return nil, err
+	}
+	return doc.Records, nil
+}
+
+// GetRecord fetches the EP3 XML export for a repository link and returns the record
+func GetRecord(link string, headers map[string]string) (*Record, error) {
+	return getRecord(link, func(api *url.URL) ([]byte, error) {
+		return Request(api, headers)
+	})
+}
+
+// getRecord is GetRecord sending the request with request (e.g. xlq.request)
+func getRecord(link string, request func(*url.URL) ([]byte, error)) (*Record, error) {
+	api, err := ExportURL(link)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := request(api)
+	if err != nil {
+		return nil, errors.New(redactURL(api.String()) + " request failed, " + err.Error())
+	}
+	records, err := ParseEP3XML(buf)
+	if err != nil {
+		return nil, errors.New("Can't parse response " + redactURL(api.String()) + ", " + err.Error())
+	}
+	if len(records) == 0 {
+		return nil, errors.New("No record found at " + redactURL(api.String()))
+	}
+	return records[0], nil
+}
+
+// recordKey identifies a record fetched this run by its export URL, so the same eprint id in two
+// repositories doesn't collide
+func recordKey(link string) (string, error) {
+	u, err := ExportURL(link)
+	if err != nil {
+		return "", err
+	}
+	u.User = nil
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+// enrichValues returns the record's values keyed by enrichLabels
+func (r *Record) enrichValues() map[string]string {
+	return map[string]string{
+		"EPrint ID":   r.EPrintID,
+		"Type":        r.Type,
+		"Authors":     r.Authors("; "),
+		"Date":        r.Date,
+		"Publication": r.Publication,
+		"DOI":         r.DOI,
+	}
+}
+
+// enrichHits adds the full record's values to each hit, fetching records by the hit's Link (or GUID)
+// unless they are already in records (keyed by recordKey). It returns the number of requests made and
+// the number of hits that couldn't be enriched.
+func (xlq *XLQuery) enrichHits(hits []Hit, records map[string]*Record) (int, int) {
+	fetched, failed := 0, 0
+	for _, hit := range hits {
+		link := hit.Values["Link"]
+		if link == "" {
+			link = hit.Values["GUID"]
+		}
+		key, err := recordKey(link)
+		if err != nil {
+			xlq.Error("Can't enrich " + link + ", " + err.Error())
+			failed++
+			continue
+		}
+		record, ok := records[key]
+		if ok == false {
+			xlq.wait()
+			record, err = getRecord(link, xlq.request)
+			fetched++
+			if err != nil {
+				xlq.Error("Can't enrich " + link + ", " + err.Error())
+				failed++
+				continue
+			}
+			records[key] = record
+		}
+		for label, val := range record.enrichValues() {
+			hit.Values[label] = val
+		}
+	}
+	return fetched, failed
+}
+
+// Identifier kinds understood by lookup mode
+const (
+	IdentifierEPrintID = "eprint"
+	IdentifierDOI      = "doi"
+	IdentifierURL      = "url"
+)
+
+// doiPrefixes are stripped from DOIs written as URLs or with a label
+var doiPrefixes = []string{
+	"https://doi.org/",
+	"http://doi.org/",
+	"https://dx.doi.org/",
+	"http://dx.doi.org/",
+	"doi:",
+}
+
+// IdentifierKind returns what sort of identifier s is (eprint id, DOI or URL) along with the
+// cleaned up identifier. An empty kind means s wasn't recognized. An eprint id is all digits,
+// optionally labelled "eprint:" (e.g. 12345 or eprint:12345). Only lookup mode, where the query
+// column holds identifiers, uses this so a title such as "1984" is still searched for as a title.
+func IdentifierKind(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	labelled := false
+	for _, prefix := range doiPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			s = strings.TrimSpace(s[len(prefix):])
+			lower = strings.ToLower(s)
+			labelled = true
+			break
+		}
+	}
+	switch {
+	case s == "":
+		return "", s
+	case strings.HasPrefix(lower, "10.") && strings.Contains(s, "/"):
+		return IdentifierDOI, s
+	case labelled == true:
+		// Labelled as a DOI but doesn't look like one
+		return "", s
+	case isLink(s):
+		return IdentifierURL, s
+	case strings.HasPrefix(lower, "eprint:"):
+		s = strings.TrimSpace(s[len("eprint:"):])
+	}
+	if isDigits(s) == true {
+		return IdentifierEPrintID, s
+	}
+	return "", s
+}
+
+// isDigits reports if s is made up only of the digits 0-9, signs and spaces aren't allowed
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// recordHit turns a record into a hit holding its title, link and enrich values
+func recordHit(record *Record) Hit {
+	hit := Hit{Values: record.enrichValues()}
+	hit.Values["Title"] = record.Title
+	hit.Values["Link"] = record.URL
+	return hit
+}
+
+// lookup resolves an eprint id, DOI or repository URL to its record(s). Eprint ids and URLs are
+// fetched directly from the repository's export, DOIs are searched for using xlq.DOISearchField
+// and only records with a matching DOI are kept. It returns the hits and number of requests made.
+func (xlq *XLQuery) lookup(api *url.URL, identifier string, records map[string]*Record) ([]Hit, int, error) {
+	kind, id := IdentifierKind(identifier)
+	links := []string{}
+	requests := 0
+	switch kind {
+	case IdentifierEPrintID:
+		u := *api
+		u.Path = "/" + id + "/"
+		u.RawQuery = ""
+		// The link ends up in the workbook so it mustn't carry credentials
+		u.User = nil
+		links = append(links, u.String())
+	case IdentifierURL:
+		links = append(links, id)
+	case IdentifierDOI:
+		u := *api
+		search := UpdateParameters(&u, map[string]string{
+			xlq.DOISearchField: id,
+			"output":           "RSS2",
+		})
+		xlq.wait()
+		buf, err := xlq.request(search)
+		requests++
+		if err != nil {
+			return nil, requests, errors.New(redactURL(search.String()) + " request failed, " + err.Error())
+		}
+		feed, err := rss2.Parse(buf)
+		if err != nil {
+			return nil, requests, errors.New("Can't parse response " + redactURL(search.String()) + ", " + err.Error())
+		}
+		results, err := feed.Filter([]string{resultMap["Link"]})
+		if err != nil {
+			return nil, requests, errors.New("filter on link error, " + err.Error())
+		}
+		links = toStrings(results[resultMap["Link"]])
+	default:
+		return nil, requests, errors.New("Can't tell what sort of identifier " + identifier + " is")
+	}
+
+	hits := []Hit{}
+	for _, link := range links {
+		key, err := recordKey(link)
+		if err != nil {
+			return nil, requests, err
+		}
+		record, ok := records[key]
+		if ok == false {
+			xlq.wait()
+			record, err = getRecord(link, xlq.request)
+			requests++
+			if err != nil {
+				return nil, requests, err
+			}
+			records[key] = record
+		}
+		if kind == IdentifierDOI && strings.EqualFold(record.DOI, id) == false {
+			continue
+		}
+		hits = append(hits, recordHit(record))
+	}
+	return hits, requests, nil
+}
@@ -0,0 +1,182 @@
+// expression.go - parses result expressions, including compound ones like {.item[].link, .item[].title}.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package excelquery
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// CompoundRows writes one row per hit with a column for each path (the default)
+	CompoundRows = "rows"
+	// CompoundCell writes one row per query with each compound expression formatted into a single cell
+	CompoundCell = "cell"
+
+	// DefaultCompoundSeparator separates the values of a hit in a compound cell
+	DefaultCompoundSeparator = " | "
+)
+
+// ParseResultExpression returns the data paths in a result expression. A compound expression lists
+// several item paths in braces, e.g. "{.item[].link, .item[].title}", a simple expression is one path.
+// Braces can't be nested and must enclose the whole expression.
+func ParseResultExpression(expr string) ([]string, error) {
+	if err := checkBraces(expr); err != nil {
+		return nil, err
+	}
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimSuffix(strings.TrimPrefix(expr, "{"), "}")
+	paths := []string{}
+	for _, p := range strings.Split(expr, ",") {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, ".") == false {
+			return nil, errors.New("expected a data path starting with '.', got " + p)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// checkBraces returns an error naming the offset of the first brace out of place in a result
+// expression, an opening brace must be its first character and the closing one its last
+func checkBraces(expr string) error {
+	first := len(expr) - len(strings.TrimLeftFunc(expr, unicode.IsSpace))
+	last := len(strings.TrimRightFunc(expr, unicode.IsSpace)) - 1
+	depth, open := 0, 0
+	for i, r := range expr {
+		switch {
+		case r == '{' && depth > 0:
+			return errors.New("nested '{' at offset " + strconv.Itoa(i) + " in result expression " + expr)
+		case r == '{' && i != first:
+			return errors.New("'{' at offset " + strconv.Itoa(i) + " should start result expression " + expr)
+		case r == '{':
+			depth, open = 1, i
+		case r == '}' && depth == 0:
+			return errors.New("unmatched '}' at offset " + strconv.Itoa(i) + " in result expression " + expr)
+		case r == '}' && i != last:
+			return errors.New("'}' at offset " + strconv.Itoa(i) + " should end result expression " + expr)
+		case r == '}':
+			depth = 0
+		}
+	}
+	if depth > 0 {
+		return errors.New("unclosed '{' at offset " + strconv.Itoa(open) + " in result expression " + expr)
+	}
+	return nil
+}
+
+// SplitResultExpressions splits a comma separated list of result expressions, commas inside
+// braces belong to a compound expression, e.g. "{.item[].link, .item[].title},.item[].guid".
+func SplitResultExpressions(s string) []string {
+	exprs := []string{}
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				if expr := strings.TrimSpace(s[start:i]); expr != "" {
+					exprs = append(exprs, expr)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if expr := strings.TrimSpace(s[start:]); expr != "" {
+		exprs = append(exprs, expr)
+	}
+	return exprs
+}
+
+// ExpandResultPaths parses each result expression returning the data paths to filter on (in order,
+// without duplicates) and the paths grouped by the expression they came from.
+func ExpandResultPaths(exprs []string) ([]string, [][]string, error) {
+	seen := map[string]bool{}
+	paths := []string{}
+	groups := [][]string{}
+	for _, expr := range exprs {
+		group, err := ParseResultExpression(expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range group {
+			if seen[p] == false {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+		groups = append(groups, group)
+	}
+	return paths, groups, nil
+}
+
+// groupLabel returns the result sheet label for a group of paths, e.g. "Link, Title"
+func groupLabel(group []string) string {
+	labels := []string{}
+	for _, p := range group {
+		labels = append(labels, labelForPath(p))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// formatCompound formats the hits for a group of paths, one hit per line with the values separated by sep
+func formatCompound(hits []Hit, group []string, sep string) string {
+	lines := []string{}
+	for _, hit := range hits {
+		values := []string{}
+		for _, p := range group {
+			values = append(values, hit.Values[labelForPath(p)])
+		}
+		lines = append(lines, strings.Join(values, sep))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// appendCompoundResult adds a single row to the result sheet for the query with a cell for each
+// group of paths holding all of the query's hits, writing a header row if the sheet is empty.
+func (xlq *XLQuery) appendCompoundResult(resultSheet *xlsx.Sheet, groups [][]string, qr *queryResult) error {
+	row := len(resultSheet.Rows)
+	if row == 0 {
+		header := xlq.queryHeader()
+		for _, group := range groups {
+			header = append(header, groupLabel(group))
+		}
+		if err := writeRow(resultSheet, row, header); err != nil {
+			return err
+		}
+		row++
+	}
+	values := xlq.queryColumns(qr)
+	for _, group := range groups {
+		values = append(values, formatCompound(qr.Hits, group, xlq.CompoundSeparator))
+	}
+	if err := writeRow(resultSheet, row, values); err != nil {
+		return err
+	}
+	if xlq.StyleResults == true {
+		styleResultRow(resultSheet, row, qr.Group%2 == 1)
+	}
+	return nil
+}
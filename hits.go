@@ -0,0 +1,85 @@
+//
+// hits.go - sorts the hits returned for a query.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SortRelevance orders hits as the repository returned them
+	SortRelevance = "relevance"
+	// SortDate orders hits newest first, using the Date (see EnrichHits) or PubDate values
+	SortDate = "date"
+	// SortScore orders hits by match score, best first (see ScoreMatches)
+	SortScore = "score"
+)
+
+// dateLayouts are the date formats found in EPrints records and RSS2 feeds
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01",
+	"2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// hitDate returns the hit's date, the zero time if it doesn't have one we can read
+func hitDate(hit Hit) time.Time {
+	for _, label := range []string{"Date", "PubDate"} {
+		val := strings.TrimSpace(hit.Values[label])
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, val); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// ValidateSortOrder returns an error if order isn't empty or one of SortRelevance, SortDate or SortScore
+func ValidateSortOrder(order string) error {
+	switch order {
+	case "", SortRelevance, SortDate, SortScore:
+		return nil
+	}
+	return errors.New("Sort order should be " + SortRelevance + ", " + SortDate + " or " + SortScore + ", got " + order)
+}
+
+// SortHits returns a sorted copy of the hits, an empty (or unknown) order leaves them as they are.
+// The hits passed in aren't reordered since rows with the same query share them.
+func SortHits(hits []Hit, order string) []Hit {
+	hits = append([]Hit{}, hits...)
+	var less func(i, j int) bool
+	switch order {
+	case SortRelevance:
+		less = func(i, j int) bool { return hits[i].Rank < hits[j].Rank }
+	case SortDate:
+		less = func(i, j int) bool { return hitDate(hits[i]).After(hitDate(hits[j])) }
+	case SortScore:
+		less = func(i, j int) bool { return hits[i].Score > hits[j].Score }
+	default:
+		return hits
+	}
+	sort.SliceStable(hits, less)
+	return hits
+}
@@ -0,0 +1,166 @@
+//
+// layout.go - the wide and outlined result sheet layouts.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"strconv"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// LayoutLong writes one row per hit repeating the query (the default)
+	LayoutLong = "long"
+	// LayoutWide writes one row per query with the hits spread across columns (e.g. "Hit1 Title", "Hit1 Link")
+	LayoutWide = "wide"
+
+	// DefaultWideHits is how many hits get columns in the wide layout
+	DefaultWideHits = 5
+)
+
+// shownHits is how many of the query's hits the result sheet has room for, the wide layout only has
+// columns for WideHits
+func (xlq *XLQuery) shownHits(qr *queryResult) int {
+	if xlq.ResultLayout == LayoutWide && len(qr.Hits) > xlq.WideHits {
+		return xlq.WideHits
+	}
+	return len(qr.Hits)
+}
+
+// cutHits reports if the result sheet leaves out some of the query's hits, dropped by MaxHits,
+// past the last page MaxPages read or without a column in the wide layout
+func (xlq *XLQuery) cutHits(qr *queryResult) bool {
+	return qr.More == true || qr.Total > xlq.shownHits(qr)
+}
+
+// truncating reports if some query of the run had hits cut, so the result sheet needs a Truncated column
+func (xlq *XLQuery) truncating() bool {
+	return xlq.truncated
+}
+
+// queryHeader returns the labels of the leading columns of the result sheet which describe the query
+func (xlq *XLQuery) queryHeader() []string {
+	header := []string{"Query Row", "Query"}
+	if xlq.normalizing() == true {
+		header = append(header, "Normalized Query")
+	}
+	if xlq.truncating() == true {
+		header = append(header, "Truncated")
+	}
+	// Highlighting follows the status so it is repeated in the result sheet
+	if xlq.StyleResults == true {
+		header = append(header, "Status")
+	}
+	return header
+}
+
+// queryColumns returns the leading values of a result row matching queryHeader()
+func (xlq *XLQuery) queryColumns(qr *queryResult) []string {
+	// Row numbers are reported as Excel displays them (e.g. first row is 1)
+	values := []string{strconv.Itoa(qr.Row + 1), qr.Query}
+	if xlq.normalizing() == true {
+		values = append(values, qr.Normalized)
+	}
+	if xlq.truncating() == true {
+		// More means the repository had hits past the last page read
+		total := strconv.Itoa(qr.Total)
+		if qr.More == true {
+			total += "+"
+		}
+		if xlq.cutHits(qr) == true {
+			values = append(values, "showing "+strconv.Itoa(xlq.shownHits(qr))+" of "+total)
+		} else {
+			values = append(values, "")
+		}
+	}
+	if xlq.StyleResults == true {
+		values = append(values, qr.Status)
+	}
+	return values
+}
+
+// appendOutlineRow writes the row a query's hits are grouped beneath in the outlined long layout
+func (xlq *XLQuery) appendOutlineRow(resultSheet *xlsx.Sheet, row int, qr *queryResult) error {
+	if err := writeRow(resultSheet, row, xlq.queryColumns(qr)); err != nil {
+		return err
+	}
+	for _, cell := range resultSheet.Rows[row].Cells {
+		style := cell.GetStyle()
+		style.Font.Bold = true
+		style.ApplyFont = true
+		cell.SetStyle(style)
+	}
+	return nil
+}
+
+// wideHeader returns the column labels for the wide layout
+func (xlq *XLQuery) wideHeader(labels []string) []string {
+	header := xlq.queryHeader()
+	for n := 1; n <= xlq.WideHits; n++ {
+		for _, label := range labels {
+			header = append(header, "Hit"+strconv.Itoa(n)+" "+label)
+		}
+		if xlq.ScoreMatches == true {
+			header = append(header, "Hit"+strconv.Itoa(n)+" Score")
+		}
+	}
+	return header
+}
+
+// appendWideResult adds a single row to the result sheet for the query with up to WideHits hits
+// spread across the columns, writing a header row if the sheet is empty.
+func (xlq *XLQuery) appendWideResult(resultSheet *xlsx.Sheet, labels []string, qr *queryResult) error {
+	row := len(resultSheet.Rows)
+	if row == 0 {
+		if err := writeRow(resultSheet, row, xlq.wideHeader(labels)); err != nil {
+			return err
+		}
+		row++
+	}
+	values := xlq.queryColumns(qr)
+	offset := len(values)
+	for n, hit := range qr.Hits {
+		if n >= xlq.WideHits {
+			break
+		}
+		for _, label := range labels {
+			values = append(values, hit.Values[label])
+		}
+		if xlq.ScoreMatches == true {
+			values = append(values, strconv.FormatFloat(hit.Score, 'f', 2, 64))
+		}
+	}
+	if err := writeRow(resultSheet, row, values); err != nil {
+		return err
+	}
+	if xlq.StyleResults == true {
+		styleResultRow(resultSheet, row, qr.Group%2 == 1)
+	}
+	if xlq.Hyperlinks == true {
+		for col := offset; col < len(values); col++ {
+			if isLink(values[col]) {
+				if err := UpdateLinkCell(resultSheet, row, col, values[col], values[col], true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
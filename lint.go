@@ -0,0 +1,277 @@
+//
+// lint.go - checks the query column for problems before any requests are sent.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// LintSheetName is the sheet the lint report is written to
+	LintSheetName = "Lint"
+
+	// DefaultLintMinLength is the query length, in characters, below which a query is reported as short
+	DefaultLintMinLength = 10
+	// DefaultLintMaxLength is the query length, in characters, above which a query is reported as long
+	DefaultLintMaxLength = 250
+
+	// Lint issues
+	LintEmpty         = "EMPTY"
+	LintFormula       = "FORMULA_NO_VALUE"
+	LintHidden        = "HIDDEN_CHARACTERS"
+	LintSpecial       = "SPECIAL_CHARACTERS"
+	LintNumeric       = "NUMERIC"
+	LintShort         = "SHORT"
+	LintLong          = "LONG"
+	LintDuplicate     = "DUPLICATE"
+	LintNearDuplicate = "NEAR_DUPLICATE"
+
+	// lintSpecialChars change how EPrints reads a search (phrases, wildcards, grouping) or are
+	// markup left over from copying a citation
+	lintSpecialChars = "\"*?()[]{}<>|\\"
+)
+
+var (
+	// lintIssues lists the issues in the order they are counted in a report
+	lintIssues = []string{LintEmpty, LintFormula, LintHidden, LintSpecial, LintNumeric, LintShort, LintLong, LintDuplicate, LintNearDuplicate}
+
+	// nearDuplicateSteps normalize queries so near duplicates (case, accents, punctuation) compare equal
+	nearDuplicateSteps = []string{"entities", "quotes", "nfkd", "fold", "punctuation", "trim"}
+)
+
+// LintIssue is a problem found with a query, Row is numbered as Excel displays it (the first row is 1)
+type LintIssue struct {
+	Row     int    `json:"row"`
+	Cell    string `json:"cell"`
+	Issue   string `json:"issue"`
+	Query   string `json:"query"`
+	Message string `json:"message"`
+}
+
+// LintReport holds the issues found in a query column
+type LintReport struct {
+	Sheet  string         `json:"sheet"`
+	Column string         `json:"column"`
+	Rows   int            `json:"rows"`
+	Issues []*LintIssue   `json:"issues"`
+	Counts map[string]int `json:"counts"`
+}
+
+// hiddenChars returns the code points of characters that don't show (or look like a plain space) in Excel
+func hiddenChars(s string) []string {
+	found := []string{}
+	for _, r := range s {
+		if unicode.IsControl(r) == true || unicode.Is(unicode.Cf, r) == true || (unicode.IsSpace(r) == true && r != ' ') {
+			found = append(found, fmt.Sprintf("U+%04X", r))
+		}
+	}
+	return found
+}
+
+// specialChars returns the characters of s found in lintSpecialChars, each once
+func specialChars(s string) string {
+	found := ""
+	for _, r := range s {
+		if strings.ContainsRune(lintSpecialChars, r) == true && strings.ContainsRune(found, r) == false {
+			found += string(r)
+		}
+	}
+	return found
+}
+
+// Lint checks each query in xlq.QueryColumn of xlq.SheetName without sending any requests. It
+// reports empty cells, formulas without a cached value, hidden and special characters, numbers,
+// queries shorter than LintMinLength or longer than LintMaxLength (or MaxQueryLength if less) and
+// duplicate or nearly duplicate queries. In lookup mode only the empty, formula, hidden character
+// and duplicate checks apply.
+func (xlq *XLQuery) Lint(workbook *xlsx.File) (*LintReport, error) {
+	sheet, err := SheetByName(workbook, xlq.SheetName)
+	if err != nil {
+		return nil, errors.New("Can't read " + xlq.WorkbookName + "." + xlq.SheetName + ", " + err.Error())
+	}
+	qIndex, err := ColumnByName(sheet, xlq.QueryColumn, xlq.SkipFirstRow)
+	if err != nil {
+		return nil, errors.New("Can't find column " + xlq.QueryColumn + ", in " + xlq.WorkbookName + "." + xlq.SheetName + ", " + err.Error())
+	}
+	maxLength := xlq.LintMaxLength
+	if xlq.MaxQueryLength > 0 && (maxLength <= 0 || xlq.MaxQueryLength < maxLength) {
+		maxLength = xlq.MaxQueryLength
+	}
+	start := 0
+	if xlq.SkipFirstRow == true {
+		start = 1
+	}
+
+	report := &LintReport{
+		Sheet:  sheet.Name,
+		Column: xlsx.ColIndexToLetters(qIndex),
+		Issues: []*LintIssue{},
+		Counts: map[string]int{},
+	}
+	// exact and near remember the first row (as displayed) holding each query
+	exact, near := map[string]int{}, map[string]int{}
+	for i := start; i < len(sheet.Rows); i++ {
+		report.Rows++
+		query := GetCell(sheet, i, qIndex)
+		add := func(issue string, message string) {
+			report.Issues = append(report.Issues, &LintIssue{
+				Row:     i + 1,
+				Cell:    xlsx.GetCellIDStringFromCoords(qIndex, i),
+				Issue:   issue,
+				Query:   query,
+				Message: message,
+			})
+			report.Counts[issue]++
+		}
+
+		trimmed := strings.TrimSpace(query)
+		if trimmed == "" {
+			if formula := sheet.Cell(i, qIndex).Formula(); formula != "" {
+				add(LintFormula, "formula "+formula+" has no saved value, open and save the workbook in Excel")
+			} else {
+				add(LintEmpty, "no query")
+			}
+			continue
+		}
+		if found := hiddenChars(query); len(found) > 0 {
+			add(LintHidden, "holds characters that don't show in Excel ("+strings.Join(found, ", ")+")")
+		}
+		if xlq.LookupMode == false {
+			if found := specialChars(trimmed); found != "" {
+				add(LintSpecial, "holds characters EPrints treats specially or markup ("+found+")")
+			}
+			if _, err := strconv.ParseFloat(trimmed, 64); err == nil {
+				add(LintNumeric, "looks like a number, eprint ids need -lookup")
+			}
+			if n := len([]rune(trimmed)); n < xlq.LintMinLength {
+				add(LintShort, "only "+strconv.Itoa(n)+" characters, likely to match many records")
+			}
+		}
+		if n := len([]rune(trimmed)); maxLength > 0 && n > maxLength {
+			add(LintLong, strconv.Itoa(n)+" characters, longer than "+strconv.Itoa(maxLength))
+		}
+
+		key := strings.ToLower(NormalizeQuery(trimmed, nearDuplicateSteps, nil, 0))
+		if row, ok := exact[trimmed]; ok == true {
+			add(LintDuplicate, "same as row "+strconv.Itoa(row))
+		} else {
+			if row, ok := near[key]; ok == true {
+				add(LintNearDuplicate, "nearly the same as row "+strconv.Itoa(row))
+			}
+			exact[trimmed] = i + 1
+		}
+		if _, ok := near[key]; ok == false {
+			near[key] = i + 1
+		}
+	}
+	return report, nil
+}
+
+// WriteLintReport writes report to out as SearchFormatTable or SearchFormatJSON
+func WriteLintReport(out io.Writer, report *LintReport, format string) error {
+	switch format {
+	case SearchFormatJSON:
+		src, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", src)
+		return err
+	case SearchFormatTable, "":
+		fmt.Fprintf(out, "%q column %s, %d rows, %d issues\n", report.Sheet, report.Column, report.Rows, len(report.Issues))
+		if len(report.Issues) == 0 {
+			return nil
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Row\tIssue\tQuery\tMessage")
+		for _, issue := range report.Issues {
+			fmt.Fprintln(w, strconv.Itoa(issue.Row)+"\t"+issue.Issue+"\t"+shorten(issue.Query, sampleWidth)+"\t"+issue.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		counts := []string{}
+		for _, issue := range lintIssues {
+			if report.Counts[issue] > 0 {
+				counts = append(counts, issue+" "+strconv.Itoa(report.Counts[issue]))
+			}
+		}
+		_, err := fmt.Fprintln(out, strings.Join(counts, ", "))
+		return err
+	}
+	return errors.New("Lint format should be " + SearchFormatTable + " or " + SearchFormatJSON + ", got " + format)
+}
+
+// writeLintSheet replaces the Lint sheet of workbook with the issues in report
+func writeLintSheet(workbook *xlsx.File, report *LintReport) error {
+	sheet, ok := workbook.Sheet[LintSheetName]
+	if ok == false {
+		var err error
+		sheet, err = workbook.AddSheet(LintSheetName)
+		if err != nil {
+			return err
+		}
+	}
+	clearSheet(sheet)
+	if err := writeRow(sheet, 0, []string{"Row", "Cell", "Issue", "Query", "Message"}); err != nil {
+		return err
+	}
+	for i, issue := range report.Issues {
+		if err := writeRow(sheet, i+1, []string{strconv.Itoa(issue.Row), issue.Cell, issue.Issue, issue.Query, issue.Message}); err != nil {
+			return err
+		}
+	}
+	return styleResultSheet(sheet)
+}
+
+// LintRunner lints the query column of xlq.WorkbookName writing the report to out as
+// SearchFormatTable or SearchFormatJSON. With lintSheet the report is also saved to the
+// workbook's Lint sheet.
+func LintRunner(xlq *XLQuery, out io.Writer, format string, lintSheet bool) error {
+	workbook, err := xlsx.OpenFile(xlq.WorkbookName)
+	if err != nil {
+		return errors.New("Can't open " + xlq.WorkbookName + ", " + err.Error())
+	}
+	report, err := xlq.Lint(workbook)
+	if err != nil {
+		return err
+	}
+	if err := WriteLintReport(out, report, format); err != nil {
+		return err
+	}
+	if lintSheet == true {
+		if err := writeLintSheet(workbook, report); err != nil {
+			return errors.New("Can't update " + xlq.WorkbookName + "." + LintSheetName + ", " + err.Error())
+		}
+		if err := workbook.Save(xlq.WorkbookName); err != nil {
+			return errors.New("Can't save " + xlq.WorkbookName + ", " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+//
+// normalize.go - cleans up query strings copied from citations before they are sent to EPrints.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	// 3rd Party packages
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// DefaultNormalizeSteps is a reasonable pipeline for titles copied from citations
+	DefaultNormalizeSteps = []string{"entities", "quotes", "nfc", "trim"}
+
+	// DefaultStopWords are dropped from queries by the "stopwords" step
+	DefaultStopWords = []string{
+		"a", "an", "and", "as", "at", "by", "for", "from", "in", "into",
+		"of", "on", "or", "the", "to", "with",
+	}
+
+	// smartQuotes maps typographic quotes and dashes to their plain ASCII equivalent
+	smartQuotes = strings.NewReplacer(
+		"‘", "'", "’", "'", "‚", "'", "‛", "'",
+		"“", `"`, "”", `"`, "„", `"`, "‟", `"`,
+		"«", `"`, "»", `"`, "‹", "'", "›", "'",
+		"–", "-", "—", "-",
+	)
+)
+
+// normalizeSteps maps the names used in NormalizeSteps to their implementation.
+// The "stopwords" step is handled in NormalizeQuery since it needs the stop word list.
+var normalizeSteps = map[string]func(string) string{
+	// entities turns HTML entities like &amp; and &#8217; into the characters they represent
+	"entities": html.UnescapeString,
+	// quotes replaces smart quotes and dashes with plain ASCII
+	"quotes": smartQuotes.Replace,
+	// nfc composes characters into Unicode Normalization Form C
+	"nfc": norm.NFC.String,
+	// nfkd decomposes characters into Unicode Normalization Form KD (e.g. ligatures become letters)
+	"nfkd": norm.NFKD.String,
+	// fold removes diacritics, e.g. "Schrödinger" becomes "Schrodinger"
+	"fold": foldDiacritics,
+	// subtitle drops everything after the first colon
+	"subtitle": dropSubtitle,
+	// punctuation replaces punctuation and symbols with spaces
+	"punctuation": stripPunctuation,
+	// trim collapses runs of whitespace and removes trailing periods, commas and semicolons
+	"trim": trimQuery,
+}
+
+// foldDiacritics decomposes a string, drops the combining marks and recomposes it
+func foldDiacritics(s string) string {
+	return norm.NFC.String(strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, norm.NFKD.String(s)))
+}
+
+// dropSubtitle returns the part of a title before the first colon
+func dropSubtitle(s string) string {
+	if i := strings.Index(s, ":"); i > 0 {
+		return s[0:i]
+	}
+	return s
+}
+
+// stripPunctuation replaces punctuation and symbols with spaces
+func stripPunctuation(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// trimQuery collapses whitespace and strips trailing punctuation left over from citations
+func trimQuery(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.TrimRight(s, " .,;")
+}
+
+// dropStopWords removes any words found in stopWords (case insensitive)
+func dropStopWords(s string, stopWords []string) string {
+	m := map[string]bool{}
+	for _, w := range stopWords {
+		m[strings.ToLower(w)] = true
+	}
+	words := []string{}
+	for _, w := range strings.Fields(s) {
+		if m[strings.ToLower(w)] == false {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// truncateQuery shortens s to at most maxLength characters, breaking on a word boundary where possible
+func truncateQuery(s string, maxLength int) string {
+	r := []rune(s)
+	if maxLength <= 0 || len(r) <= maxLength {
+		return s
+	}
+	t := string(r[0:maxLength])
+	if i := strings.LastIndex(t, " "); i > 0 && unicode.IsSpace(r[maxLength]) == false {
+		t = t[0:i]
+	}
+	return strings.TrimSpace(t)
+}
+
+// ValidateNormalizeSteps returns an error naming the first unknown step
+func ValidateNormalizeSteps(steps []string) error {
+	for _, step := range steps {
+		if _, ok := normalizeSteps[step]; ok == false && step != "stopwords" {
+			return errors.New("Unknown normalize step " + step + ", expected entities, quotes, nfc, nfkd, fold, subtitle, punctuation, stopwords or trim")
+		}
+	}
+	return nil
+}
+
+// NormalizeQuery applies each named step in order to the query string then truncates the result
+// to maxLength characters (0 means no limit). Steps are "entities", "quotes", "nfc", "nfkd", "fold",
+// "subtitle", "punctuation", "stopwords" and "trim". Whitespace is always collapsed at the end.
+func NormalizeQuery(s string, steps []string, stopWords []string, maxLength int) string {
+	for _, step := range steps {
+		if step == "stopwords" {
+			s = dropStopWords(s, stopWords)
+		} else if fn, ok := normalizeSteps[step]; ok == true {
+			s = fn(s)
+		}
+	}
+	return truncateQuery(strings.Join(strings.Fields(s), " "), maxLength)
+}
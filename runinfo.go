@@ -0,0 +1,170 @@
+//
+// runinfo.go - records how a result sheet was produced so the run can be repeated.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// RunInfoSheetName is the sheet recording the last run's settings and counts
+	RunInfoSheetName = "Run Info"
+	// runConfigLabel labels the row holding the run's JobConfig as JSON
+	runConfigLabel = "Config"
+)
+
+// runInfo holds the counts of a run
+type runInfo struct {
+	Started  time.Time
+	FirstRow int
+	LastRow  int
+	Requests int
+	Reused   int
+	Statuses map[string]int
+}
+
+// writeRunInfo replaces the Run Info sheet with the version, time, settings and counts of the run.
+// The last row holds the settings as JSON for ReadRunInfo.
+func (xlq *XLQuery) writeRunInfo(workbook *xlsx.File, info *runInfo) error {
+	sheet, ok := workbook.Sheet[RunInfoSheetName]
+	if ok == false {
+		var err error
+		sheet, err = workbook.AddSheet(RunInfoSheetName)
+		if err != nil {
+			return err
+		}
+	}
+	clearSheet(sheet)
+
+	// Credentials aren't part of JobConfig, a password in the URL is hidden as well
+	cfg := NewJobConfig(xlq)
+	cfg.EPrintsSearchURL = redactURL(cfg.EPrintsSearchURL)
+	src, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	params := []string{}
+	for key, val := range xlq.SearchParameters {
+		params = append(params, key+"="+val)
+	}
+	sort.Strings(params)
+	// Only the names of the headers are recorded, their values may be secrets
+	headers := []string{}
+	for key := range xlq.RequestHeaders() {
+		headers = append(headers, key)
+	}
+	sort.Strings(headers)
+	rows := "none"
+	if info.LastRow >= info.FirstRow {
+		// Rows are reported as Excel displays them (e.g. first row is 1)
+		rows = strconv.Itoa(info.FirstRow+1) + "-" + strconv.Itoa(info.LastRow+1)
+	}
+
+	values := [][]string{
+		{"Setting", "Value"},
+		{"Version", Version},
+		{"Started", info.Started.Format(time.RFC3339)},
+		{"Finished", time.Now().Format(time.RFC3339)},
+		{"Repository", cfg.EPrintsSearchURL},
+		{"Query Parameter", xlq.QueryParameter},
+		{"Search Parameters", strings.Join(params, ", ")},
+		{"Request Headers", strings.Join(headers, ", ")},
+		{"Proxy", redactURL(xlq.ProxyURL)},
+		{"CA Bundle", xlq.CABundle},
+		{"Result Paths", strings.Join(xlq.ResultDataPaths, ", ")},
+		{"Query Sheet", xlq.SheetName},
+		{"Query Column", xlq.QueryColumn},
+		{"Result Sheet", xlq.ResultSheetName},
+		{"Rows", rows},
+		{"Requests", strconv.Itoa(info.Requests)},
+		{"Reused Results", strconv.Itoa(info.Reused)},
+	}
+	for _, status := range []string{StatusOK, StatusNoHits, StatusManyHits, StatusError} {
+		values = append(values, []string{status, strconv.Itoa(info.Statuses[status])})
+	}
+	values = append(values, []string{runConfigLabel, string(src)})
+	for i, row := range values {
+		if err := writeRow(sheet, i, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRunInfo returns the settings recorded in the workbook's Run Info sheet
+func ReadRunInfo(workbook *xlsx.File) (*JobConfig, error) {
+	sheet, ok := workbook.Sheet[RunInfoSheetName]
+	if ok == false {
+		return nil, errors.New("No " + RunInfoSheetName + " sheet")
+	}
+	for i := range sheet.Rows {
+		if GetCell(sheet, i, 0) == runConfigLabel {
+			xlq := new(XLQuery)
+			xlq.Init()
+			cfg := NewJobConfig(xlq)
+			if err := json.Unmarshal([]byte(GetCell(sheet, i, 1)), cfg); err != nil {
+				return nil, errors.New("Can't read " + RunInfoSheetName + ", " + err.Error())
+			}
+			return cfg, nil
+		}
+	}
+	return nil, errors.New("No " + runConfigLabel + " row in " + RunInfoSheetName)
+}
+
+// RerunRunner repeats the run recorded in the Run Info sheet of fname, replacing its result sheet
+func RerunRunner(xlq *XLQuery, fname string, println func(string)) error {
+	workbook, err := xlsx.OpenFile(fname)
+	if err != nil {
+		return errors.New("Can't open " + fname + ", " + err.Error())
+	}
+	cfg, err := ReadRunInfo(workbook)
+	if err != nil {
+		return errors.New("Can't rerun " + fname + ", " + err.Error())
+	}
+	// A password in the search URL was redacted when it was recorded so it has to be supplied again
+	if u, err := url.Parse(cfg.EPrintsSearchURL); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok == true && password == redactedPassword {
+			if xlq.Password == "" {
+				return errors.New("Can't rerun " + fname + ", the password of " + cfg.EPrintsSearchURL + " wasn't recorded, supply it with -user " + u.User.Username() + " and EXCELQUERY_PASSWORD")
+			}
+			if xlq.Username == "" {
+				xlq.Username = u.User.Username()
+			}
+			u.User = nil
+			cfg.EPrintsSearchURL = u.String()
+		}
+	}
+	if err := cfg.Apply(xlq); err != nil {
+		return err
+	}
+	// The workbook may have been moved or renamed since and the result sheet now exists
+	xlq.WorkbookName = fname
+	xlq.OverwriteResult = true
+	println("Rerunning " + fname + " with the settings from " + RunInfoSheetName)
+	return CliRunner(xlq, println)
+}
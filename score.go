@@ -0,0 +1,151 @@
+//
+// score.go - scores how closely the hits returned by a search match the query string.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// DefaultBestMatchMargin is how far ahead of the runner up the top score needs to be for a clear winner
+	DefaultBestMatchMargin = 0.2
+)
+
+// tokenize lower cases a string and splits it into words, dropping punctuation
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsNumber(r) == false
+	})
+}
+
+// tokenOverlap returns the Dice coefficient of the two token lists, 0.0 to 1.0
+func tokenOverlap(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0.0
+	}
+	counts := map[string]int{}
+	for _, t := range a {
+		counts[t]++
+	}
+	shared := 0
+	for _, t := range b {
+		if counts[t] > 0 {
+			counts[t]--
+			shared++
+		}
+	}
+	return float64(2*shared) / float64(len(a)+len(b))
+}
+
+// editDistance returns the Levenshtein distance between two rune slices
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// MatchScore returns how similar a hit's title is to the query string, from 0.0 (nothing in
+// common) to 1.0 (the same once case, punctuation and spacing are ignored). It averages the
+// token overlap with a normalized edit distance so reordered words and small typos both score well.
+func MatchScore(query, title string) float64 {
+	q, t := tokenize(query), tokenize(title)
+	if len(q) == 0 || len(t) == 0 {
+		return 0.0
+	}
+	a, b := []rune(strings.Join(q, " ")), []rune(strings.Join(t, " "))
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	similarity := 1.0 - float64(editDistance(a, b))/float64(longest)
+	return (tokenOverlap(q, t) + similarity) / 2.0
+}
+
+// ScoreHits scores each hit's title against the query, drops any scoring below minScore
+// and returns the remaining hits sorted from best to worst match.
+func ScoreHits(query string, hits []Hit, minScore float64) []Hit {
+	scored := []Hit{}
+	for _, hit := range hits {
+		hit.Score = MatchScore(query, hit.Values["Title"])
+		if hit.Score >= minScore {
+			scored = append(scored, hit)
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	return scored
+}
+
+// BestMatch returns the top scoring hit if it is ahead of the runner up by at least margin.
+// Hits are expected to be sorted by score as returned by ScoreHits().
+func BestMatch(hits []Hit, margin float64) (Hit, bool) {
+	if len(hits) == 0 || hits[0].Score <= 0.0 {
+		return Hit{}, false
+	}
+	if len(hits) > 1 && hits[0].Score-hits[1].Score < margin {
+		return Hit{}, false
+	}
+	return hits[0], true
+}
+
+// updateBestMatch writes the best match's link and title into the query sheet if those columns are configured
+func updateBestMatch(sheet *xlsx.Sheet, row int, linkCol int, titleCol int, best Hit, hyperlink bool) error {
+	if linkCol >= 0 {
+		link := best.Values["Link"]
+		if hyperlink == true && isLink(link) {
+			if err := UpdateLinkCell(sheet, row, linkCol, link, link, true); err != nil {
+				return err
+			}
+		} else if err := UpdateCell(sheet, row, linkCol, link, true); err != nil {
+			return err
+		}
+	}
+	if titleCol >= 0 {
+		if err := UpdateCell(sheet, row, titleCol, best.Values["Title"], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
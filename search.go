@@ -0,0 +1,147 @@
+//
+// search.go - runs a single query outside of a workbook.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// SearchFormatTable writes the hits as aligned columns
+	SearchFormatTable = "table"
+	// SearchFormatJSON writes the SearchResult as JSON
+	SearchFormatJSON = "json"
+	// SearchFormatText writes one value of the first hit, e.g. for Excel's WEBSERVICE() function
+	SearchFormatText = "text"
+)
+
+// SearchResult holds the outcome of a single query, More is set when MaxPages stopped the search
+// on a full page so Total only counts the hits read
+type SearchResult struct {
+	Query      string   `json:"query"`
+	Normalized string   `json:"normalized"`
+	Status     string   `json:"status"`
+	Total      int      `json:"total"`
+	More       bool     `json:"more,omitempty"`
+	Labels     []string `json:"labels"`
+	Hits       []Hit    `json:"hits"`
+}
+
+// Search runs one query through the same steps as a row of CliRunner (see runQuery), normalizing,
+// searching (or looking up), enriching, scoring, sorting and truncating the hits with the XLQuery's settings
+func (xlq *XLQuery) Search(query string) (*SearchResult, error) {
+	if err := ValidateNormalizeSteps(xlq.NormalizeSteps); err != nil {
+		return nil, err
+	}
+	if err := ValidateSortOrder(xlq.SortOrder); err != nil {
+		return nil, err
+	}
+	api, err := url.Parse(xlq.EPrintsSearchURL)
+	if err != nil {
+		return nil, errors.New("Can't parse " + xlq.EPrintsSearchURL + ", " + err.Error())
+	}
+	dataPaths, _, err := ExpandResultPaths(xlq.ResultDataPaths)
+	if err != nil {
+		return nil, err
+	}
+	if err := xlq.startJob(); err != nil {
+		return nil, err
+	}
+	qr := &queryResult{Query: query}
+	xlq.runQuery(api, qr, dataPaths, map[string]*Record{}, nil)
+	if qr.Err != nil {
+		return nil, qr.Err
+	}
+	res := &SearchResult{
+		Query:      qr.Query,
+		Normalized: qr.Normalized,
+		Status:     qr.Status,
+		Total:      qr.Total,
+		More:       qr.More,
+		Labels:     xlq.hitLabels(dataPaths),
+		Hits:       qr.Hits,
+	}
+	if res.Hits == nil {
+		res.Hits = []Hit{}
+	}
+	return res, nil
+}
+
+// WriteSearchResult writes res to out as SearchFormatTable, SearchFormatJSON or SearchFormatText.
+// With SearchFormatText field names the value written, "Link" if empty.
+func WriteSearchResult(out io.Writer, res *SearchResult, format string, field string) error {
+	switch format {
+	case SearchFormatJSON:
+		src, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", src)
+		return err
+	case SearchFormatText:
+		if field == "" {
+			field = "Link"
+		}
+		if len(res.Hits) > 0 {
+			_, err := fmt.Fprint(out, res.Hits[0].Values[field])
+			return err
+		}
+		return nil
+	case SearchFormatTable, "":
+		scored := false
+		for _, hit := range res.Hits {
+			if hit.Score > 0 {
+				scored = true
+			}
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		header := []string{"Rank"}
+		if scored == true {
+			header = append(header, "Score")
+		}
+		fmt.Fprintln(w, strings.Join(append(header, res.Labels...), "\t"))
+		for _, hit := range res.Hits {
+			row := []string{fmt.Sprintf("%d", hit.Rank)}
+			if scored == true {
+				row = append(row, fmt.Sprintf("%.2f", hit.Score))
+			}
+			for _, label := range res.Labels {
+				// Tabs and line breaks would break the table's columns
+				row = append(row, strings.Join(strings.Fields(hit.Values[label]), " "))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		more := ""
+		if res.More == true {
+			more = "+"
+		}
+		_, err := fmt.Fprintf(out, "%s, %d%s hits\n", res.Status, res.Total, more)
+		return err
+	}
+	return errors.New("Search format should be " + SearchFormatTable + ", " + SearchFormatJSON + " or " + SearchFormatText + ", got " + format)
+}
@@ -0,0 +1,428 @@
+//
+// server.go - runs workbook queries as jobs over HTTP.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JobQueued, JobRunning, JobDone and JobFailed are the states of a server job
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	// DefaultMaxUpload is the largest workbook the server accepts, in bytes
+	DefaultMaxUpload = 32 << 20
+	// DefaultJobLifetime is how long a finished job's workbook is kept for download
+	DefaultJobLifetime = time.Hour
+	// DefaultQueueLength is how many jobs can wait for a worker
+	DefaultQueueLength = 64
+
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// clientOptions are the SetOption names a request may change. The repository searched and the
+// profile choosing it stay as the server was started so the credentials, headers and connection
+// settings in its defaults are only ever sent to that repository. RunInfo is left out as the Run
+// Info sheet records those connection settings (e.g. the proxy and CA bundle).
+var clientOptions = map[string]bool{
+	"ResultDataPaths": true,
+	"WorkbookName":    true,
+	"SheetName":       true,
+	"QueryColumn":     true,
+	"ResultSheetName": true,
+	"SkipFirstRow":    true,
+	"OverwriteResult": true,
+	"StatusColumn":    true,
+	"HitCountColumn":  true,
+	"ScoreMatches":    true,
+	"StyleResults":    true,
+	"Hyperlinks":      true,
+	"QueryParameter":  true,
+}
+
+// Job is a workbook submitted to the server, its JSON form is the job status
+type Job struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	WorkbookName string    `json:"workbook"`
+	Done         int       `json:"done"`
+	Total        int       `json:"total"`
+	Messages     []string  `json:"messages"`
+	Errors       []string  `json:"errors"`
+	Created      time.Time `json:"created"`
+	Finished     time.Time `json:"finished"`
+
+	xlq    *XLQuery
+	src    []byte
+	result []byte
+}
+
+// Server runs the CliRunner pipeline for uploaded workbooks. POST /run returns the processed
+// workbook directly, POST /jobs queues it and returns the job's status, GET /jobs/ID reports
+// the status, GET /jobs/ID/workbook downloads the result once it is done and GET /search?q=TITLE
+// runs a single query.
+type Server struct {
+	// Defaults holds the settings each job starts with, requests may change some of them with SetOption names
+	Defaults *XLQuery
+	// MaxUpload is the largest workbook accepted, in bytes
+	MaxUpload int64
+	// JobLifetime is how long finished jobs are kept
+	JobLifetime time.Duration
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+}
+
+// NewServer creates a Server with workers processing the job queue in the background
+func NewServer(defaults *XLQuery, workers int) *Server {
+	s := &Server{
+		Defaults:    defaults,
+		MaxUpload:   DefaultMaxUpload,
+		JobLifetime: DefaultJobLifetime,
+		jobs:        map[string]*Job{},
+		queue:       make(chan *Job, DefaultQueueLength),
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	// Jobs copy the defaults, a limiter set here spaces the requests of all jobs by RequestDelay
+	if defaults.limiter == nil {
+		defaults.limiter = new(limiter)
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// newJobID returns a random id so jobs can't be guessed on a shared server
+func newJobID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newQuery copies the server's defaults and applies the request's settings
+func (s *Server) newQuery(values url.Values) (*XLQuery, error) {
+	xlq := new(XLQuery)
+	*xlq = *s.Defaults
+	xlq.ErrorList = []string{}
+	xlq.MessageList = []string{}
+	xlq.Progress = nil
+	// Settings may add to the search parameters, keep the defaults' map as it is
+	xlq.SearchParameters = map[string]string{}
+	for key, val := range s.Defaults.SearchParameters {
+		xlq.SearchParameters[key] = val
+	}
+	for name, val := range values {
+		if len(val) == 0 {
+			continue
+		}
+		if clientOptions[name] == false {
+			return nil, errors.New("Setting " + name + " can't be changed by a request")
+		}
+		if msg := xlq.SetOption(name, val[0]); msg != "" {
+			return nil, errors.New(msg)
+		}
+	}
+	return xlq, nil
+}
+
+// readUpload returns the workbook and settings from a multipart form (the workbook in the
+// "workbook" field) or from a raw xlsx body with the settings in the URL's query string
+func (s *Server) readUpload(w http.ResponseWriter, r *http.Request) (*XLQuery, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUpload)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(s.MaxUpload); err != nil {
+			return nil, nil, errors.New("Can't read form, " + err.Error())
+		}
+		xlq, err := s.newQuery(r.Form)
+		if err != nil {
+			return nil, nil, err
+		}
+		fp, header, err := r.FormFile("workbook")
+		if err != nil {
+			return nil, nil, errors.New("Can't read workbook, " + err.Error())
+		}
+		defer fp.Close()
+		src, err := ioutil.ReadAll(fp)
+		if err != nil {
+			return nil, nil, errors.New("Can't read workbook, " + err.Error())
+		}
+		if r.Form.Get("WorkbookName") == "" && header.Filename != "" {
+			xlq.WorkbookName = header.Filename
+		}
+		return xlq, src, nil
+	}
+	xlq, err := s.newQuery(r.URL.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, errors.New("Can't read workbook, " + err.Error())
+	}
+	if len(src) == 0 {
+		return nil, nil, errors.New("Expected an xlsx workbook")
+	}
+	return xlq, src, nil
+}
+
+// runErrors returns the errors of a run, err is only added if the run didn't record it already
+func runErrors(xlq *XLQuery, err error) []string {
+	errs := append([]string{}, xlq.ErrorList...)
+	if err != nil && len(errs) == 0 {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+// status copies the job's status so it can be written once s.mu is released, the caller holds s.mu
+func (job *Job) status() *Job {
+	return &Job{
+		ID:           job.ID,
+		Status:       job.Status,
+		WorkbookName: job.WorkbookName,
+		Done:         job.Done,
+		Total:        job.Total,
+		Messages:     append([]string{}, job.Messages...),
+		Errors:       append([]string{}, job.Errors...),
+		Created:      job.Created,
+		Finished:     job.Finished,
+	}
+}
+
+// worker processes queued jobs until the queue is closed
+func (s *Server) worker() {
+	for job := range s.queue {
+		s.mu.Lock()
+		job.Status = JobRunning
+		s.mu.Unlock()
+
+		job.xlq.Progress = func(done int, total int) {
+			s.mu.Lock()
+			job.Done, job.Total = done, total
+			s.mu.Unlock()
+		}
+		out, err := job.xlq.RunBinary(job.src, func(msg string) {
+			s.mu.Lock()
+			job.Messages = append(job.Messages, msg)
+			s.mu.Unlock()
+		})
+
+		s.mu.Lock()
+		job.Errors = runErrors(job.xlq, err)
+		job.Status = JobDone
+		if err != nil {
+			job.Status = JobFailed
+		}
+		job.result = out
+		job.src = nil
+		job.Finished = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// expire drops finished jobs older than JobLifetime, the caller holds s.mu
+func (s *Server) expire() {
+	for id, job := range s.jobs {
+		if job.Finished.IsZero() == false && time.Since(job.Finished) > s.JobLifetime {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// writeJSON writes val as the JSON response with status code
+func writeJSON(w http.ResponseWriter, code int, val interface{}) {
+	src, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(src)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, code int, errs ...string) {
+	writeJSON(w, code, map[string][]string{"errors": errs})
+}
+
+// writeWorkbook sends an xlsx workbook as a download
+func writeWorkbook(w http.ResponseWriter, name string, src []byte) {
+	w.Header().Set("Content-Type", xlsxContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.Replace(name, `"`, "", -1)+`"`)
+	w.Write(src)
+}
+
+// handleRun processes the upload while the client waits and returns the workbook
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	xlq, src, err := s.readUpload(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := xlq.RunBinary(src, func(string) {})
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, runErrors(xlq, err)...)
+		return
+	}
+	writeWorkbook(w, xlq.WorkbookName, out)
+}
+
+// handleSubmit queues the upload as a job and returns its status
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	xlq, src, err := s.readUpload(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job := &Job{
+		ID:           id,
+		Status:       JobQueued,
+		WorkbookName: xlq.WorkbookName,
+		Messages:     []string{},
+		Errors:       []string{},
+		Created:      time.Now(),
+		xlq:          xlq,
+		src:          src,
+	}
+	s.mu.Lock()
+	s.expire()
+	select {
+	case s.queue <- job:
+		s.jobs[id] = job
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "Job queue is full, try again later")
+		return
+	}
+	// A worker may already have the job, its status is copied before the response is written
+	status := job.status()
+	s.mu.Unlock()
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// handleJob reports a job's status or sends its workbook
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	download := false
+	if strings.HasSuffix(id, "/workbook") == true {
+		id, download = strings.TrimSuffix(id, "/workbook"), true
+	}
+	// The job is copied under the lock so a slow client doesn't hold up the workers
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var status *Job
+	var result []byte
+	if ok == true {
+		status, result = job.status(), job.result
+	}
+	s.mu.Unlock()
+	if ok == false {
+		writeError(w, http.StatusNotFound, "No job "+id)
+		return
+	}
+	if download == false {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	if status.Status != JobDone {
+		writeError(w, http.StatusConflict, "Job "+id+" is "+status.Status)
+		return
+	}
+	writeWorkbook(w, status.WorkbookName, result)
+}
+
+// handleSearch runs a single query, GET /search?q=TITLE with optional format (json, table or text),
+// field (the value returned by the text format) and settings
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query, format, field := values.Get("q"), values.Get("format"), values.Get("field")
+	for _, name := range []string{"q", "format", "field"} {
+		values.Del(name)
+	}
+	if strings.TrimSpace(query) == "" {
+		writeError(w, http.StatusBadRequest, "Expected a query, e.g. /search?q=TITLE")
+		return
+	}
+	if format == "" {
+		format = SearchFormatJSON
+	}
+	xlq, err := s.newQuery(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := xlq.Search(query)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteSearchResult(buf, res, format, field); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format == SearchFormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+// ServeHTTP routes the server's requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/run" && r.Method == "POST":
+		s.handleRun(w, r)
+	case r.URL.Path == "/jobs" && r.Method == "POST":
+		s.handleSubmit(w, r)
+	case strings.HasPrefix(r.URL.Path, "/jobs/") && r.Method == "GET":
+		s.handleJob(w, r)
+	case r.URL.Path == "/search" && r.Method == "GET":
+		s.handleSearch(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "Expected POST /run, POST /jobs, GET /jobs/ID or GET /search?q=TITLE")
+	}
+}
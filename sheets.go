@@ -0,0 +1,167 @@
+//
+// sheets.go - summarizes the sheets of a workbook so the query sheet and column can be chosen.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// DefaultSampleValues is how many values of each column a sheet summary shows
+	DefaultSampleValues = 3
+
+	// sampleWidth is the most characters of a sample value shown in a table
+	sampleWidth = 30
+)
+
+// ColumnSummary describes a column of a sheet, Values counts its non-empty cells below the header
+type ColumnSummary struct {
+	Column  string   `json:"column"`
+	Header  string   `json:"header,omitempty"`
+	Values  int      `json:"values"`
+	Samples []string `json:"samples"`
+}
+
+// SheetSummary describes a sheet, Rows and Columns cover the cells holding values
+type SheetSummary struct {
+	Index      int              `json:"index"`
+	Name       string           `json:"name"`
+	Rows       int              `json:"rows"`
+	Columns    int              `json:"columns"`
+	ColumnInfo []*ColumnSummary `json:"column_info"`
+}
+
+// SummarizeSheet describes sheet, when header is true the first row holds the column names.
+// The first samples distinct values of each column are kept.
+func SummarizeSheet(sheet *xlsx.Sheet, index int, header bool, samples int) *SheetSummary {
+	summary := &SheetSummary{Index: index, Name: sheet.Name, ColumnInfo: []*ColumnSummary{}}
+	for i, row := range sheet.Rows {
+		if row == nil {
+			continue
+		}
+		for j := range row.Cells {
+			if strings.TrimSpace(GetCell(sheet, i, j)) != "" {
+				if i+1 > summary.Rows {
+					summary.Rows = i + 1
+				}
+				if j+1 > summary.Columns {
+					summary.Columns = j + 1
+				}
+			}
+		}
+	}
+	start := 0
+	if header == true {
+		start = 1
+	}
+	for j := 0; j < summary.Columns; j++ {
+		col := &ColumnSummary{Column: xlsx.ColIndexToLetters(j), Samples: []string{}}
+		if header == true {
+			col.Header = strings.TrimSpace(GetCell(sheet, 0, j))
+		}
+		seen := map[string]bool{}
+		for i := start; i < summary.Rows; i++ {
+			val := strings.TrimSpace(GetCell(sheet, i, j))
+			if val == "" {
+				continue
+			}
+			col.Values++
+			if len(col.Samples) < samples && seen[val] == false {
+				seen[val] = true
+				col.Samples = append(col.Samples, val)
+			}
+		}
+		summary.ColumnInfo = append(summary.ColumnInfo, col)
+	}
+	return summary
+}
+
+// SummarizeSheets describes each sheet of workbook in order, see SummarizeSheet
+func SummarizeSheets(workbook *xlsx.File, header bool, samples int) []*SheetSummary {
+	summaries := []*SheetSummary{}
+	for i, sheet := range workbook.Sheets {
+		summaries = append(summaries, SummarizeSheet(sheet, i, header, samples))
+	}
+	return summaries
+}
+
+// shorten cuts s to width characters on one line for a table cell
+func shorten(s string, width int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > width {
+		return string(r[0:width-3]) + "..."
+	}
+	return s
+}
+
+// WriteSheetSummaries writes summaries to out as SearchFormatTable or SearchFormatJSON
+func WriteSheetSummaries(out io.Writer, summaries []*SheetSummary, format string) error {
+	switch format {
+	case SearchFormatJSON:
+		src, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", src)
+		return err
+	case SearchFormatTable, "":
+		for i, summary := range summaries {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%d %q, %d rows, %d columns\n", summary.Index, summary.Name, summary.Rows, summary.Columns)
+			if len(summary.ColumnInfo) == 0 {
+				continue
+			}
+			w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "  Column\tHeader\tValues\tSamples")
+			for _, col := range summary.ColumnInfo {
+				samples := []string{}
+				for _, val := range col.Samples {
+					samples = append(samples, shorten(val, sampleWidth))
+				}
+				fmt.Fprintln(w, "  "+col.Column+"\t"+shorten(col.Header, sampleWidth)+"\t"+strconv.Itoa(col.Values)+"\t"+strings.Join(samples, " | "))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("Sheets format should be " + SearchFormatTable + " or " + SearchFormatJSON + ", got " + format)
+}
+
+// SheetsRunner writes a summary of each sheet in xlq.WorkbookName to out, the first row is taken
+// as the header when xlq.SkipFirstRow is true
+func SheetsRunner(xlq *XLQuery, out io.Writer, format string, samples int) error {
+	workbook, err := xlsx.OpenFile(xlq.WorkbookName)
+	if err != nil {
+		return errors.New("Can't open " + xlq.WorkbookName + ", " + err.Error())
+	}
+	return WriteSheetSummaries(out, SummarizeSheets(workbook, xlq.SkipFirstRow, samples), format)
+}
@@ -0,0 +1,317 @@
+//
+// style.go - formats the result sheet for review.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package excelquery
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// 3rd Party packages
+	"github.com/tealeg/xlsx"
+)
+
+const (
+	// DefaultLowScore is the match score below which a hit is highlighted
+	DefaultLowScore = 0.5
+
+	// Fill colors (ARGB) used when styling the result sheet
+	headerFill   = "FFD9D9D9"
+	bandFill     = "FFF2F2F2"
+	noHitsFill   = "FFF4CCCC"
+	manyHitsFill = "FFFFF2CC"
+	lowScoreFill = "FFFCE4D6"
+
+	// Column widths are kept between these values, in characters
+	minColWidth = 8.0
+	maxColWidth = 60.0
+)
+
+// fillRow sets a solid fill color on each cell in the row
+func fillRow(sheet *xlsx.Sheet, row int, color string) {
+	for _, cell := range sheet.Rows[row].Cells {
+		style := cell.GetStyle()
+		style.Fill = *xlsx.NewFill("solid", color, color)
+		style.ApplyFill = true
+		cell.SetStyle(style)
+	}
+}
+
+// styleResultRow bands a result row so the hits for each query stand out from their neighbors,
+// band alternates with each query written to the sheet
+func styleResultRow(sheet *xlsx.Sheet, row int, band bool) {
+	if band == true {
+		fillRow(sheet, row, bandFill)
+	}
+}
+
+// highlight is a conditional format, the rows of Range in the sheet named Sheet are filled with Fill
+// while Formula (written for the first row of Range) is true
+type highlight struct {
+	Sheet   string
+	Range   string
+	Formula string
+	Fill    string
+}
+
+// headerColumn returns the column of the sheet's header row holding label, or -1
+func headerColumn(sheet *xlsx.Sheet, label string) int {
+	if len(sheet.Rows) > 0 {
+		for i, cell := range sheet.Rows[0].Cells {
+			if cell.Value == label {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// highlightResults records conditional formats for the result sheet, rows with no hits, too many
+// hits or a low scoring hit are highlighted. They are formats rather than fills so the highlighting
+// follows the Status and Score cells when the sheet is edited or sorted.
+func (xlq *XLQuery) highlightResults(sheet *xlsx.Sheet) {
+	if len(sheet.Rows) < 2 || len(sheet.Rows[0].Cells) == 0 {
+		return
+	}
+	cellRange := "A2:" + xlsx.GetCellIDStringFromCoords(len(sheet.Rows[0].Cells)-1, len(sheet.Rows)-1)
+	if col := headerColumn(sheet, "Status"); col >= 0 {
+		status := "$" + xlsx.ColIndexToLetters(col) + "2"
+		xlq.highlights = append(xlq.highlights,
+			&highlight{Sheet: sheet.Name, Range: cellRange, Formula: status + `="` + StatusNoHits + `"`, Fill: noHitsFill},
+			&highlight{Sheet: sheet.Name, Range: cellRange, Formula: status + `="` + StatusManyHits + `"`, Fill: manyHitsFill},
+		)
+	}
+	if xlq.ScoreMatches == true {
+		// The wide layout is highlighted by its best hit
+		col := headerColumn(sheet, "Score")
+		if col < 0 {
+			col = headerColumn(sheet, "Hit1 Score")
+		}
+		if col >= 0 {
+			// Scores are written as text, VALUE() fails on an empty cell so those rows aren't highlighted
+			score := "$" + xlsx.ColIndexToLetters(col) + "2"
+			xlq.highlights = append(xlq.highlights,
+				&highlight{Sheet: sheet.Name, Range: cellRange, Formula: "VALUE(" + score + ")<" + strconv.FormatFloat(xlq.LowScore, 'f', -1, 64), Fill: lowScoreFill},
+			)
+		}
+	}
+}
+
+// writeWorkbook writes workbook to out adding the conditional formats recorded by highlightResults
+func (xlq *XLQuery) writeWorkbook(workbook *xlsx.File, out io.Writer) error {
+	buf := new(bytes.Buffer)
+	if err := workbook.Write(buf); err != nil {
+		return err
+	}
+	src := buf.Bytes()
+	if len(xlq.highlights) > 0 {
+		sheets := map[string]int{}
+		for i, sheet := range workbook.Sheets {
+			sheets[sheet.Name] = i + 1
+		}
+		var err error
+		src, err = addConditionalFormats(src, sheets, xlq.highlights)
+		if err != nil {
+			return errors.New("Can't add highlighting, " + err.Error())
+		}
+	}
+	_, err := out.Write(src)
+	return err
+}
+
+var (
+	// dxfsElement matches the differential formats of styles.xml, conditional formats refer to them by position
+	dxfsElement = regexp.MustCompile(`<dxfs[^>]*?(/>|>(?s:.*?)</dxfs>)`)
+	dxfsCount   = regexp.MustCompile(`<dxf[ >/]`)
+	// after the conditional formats of a worksheet come these elements, in this order
+	afterConditionalFormats = []string{"<dataValidations", "<hyperlinks", "<printOptions", "<pageMargins", "<pageSetup", "<headerFooter", "<drawing", "<legacyDrawing", "<tableParts", "<extLst", "</worksheet>"}
+)
+
+// xmlText escapes s for use as XML text or an attribute value
+func xmlText(s string) string {
+	buf := new(bytes.Buffer)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+// addConditionalFormats adds highlights to an xlsx file, sheets maps sheet names to their position
+// (from 1) which names their part, e.g. xl/worksheets/sheet1.xml. xlsx can't write conditional
+// formats itself so they are added to styles.xml and the worksheets' XML.
+func addConditionalFormats(src []byte, sheets map[string]int, highlights []*highlight) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, err
+	}
+	parts := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		parts[f.Name], err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Each highlight's fill is a differential format added after any already in styles.xml
+	styles, ok := parts["xl/styles.xml"]
+	if ok == false {
+		return nil, errors.New("no xl/styles.xml")
+	}
+	existing := dxfsElement.Find(styles)
+	first := len(dxfsCount.FindAll(existing, -1))
+	dxfs := []string{}
+	for _, h := range highlights {
+		dxfs = append(dxfs, `<dxf><fill><patternFill patternType="solid"><fgColor rgb="`+h.Fill+`"/><bgColor rgb="`+h.Fill+`"/></patternFill></fill></dxf>`)
+	}
+	if existing != nil {
+		inner := ""
+		if bytes.HasSuffix(existing, []byte("</dxfs>")) {
+			inner = string(existing[bytes.IndexByte(existing, '>')+1 : len(existing)-len("</dxfs>")])
+		}
+		replacement := `<dxfs count="` + strconv.Itoa(first+len(dxfs)) + `">` + inner + strings.Join(dxfs, "") + `</dxfs>`
+		styles = bytes.Replace(styles, existing, []byte(replacement), 1)
+	} else {
+		// dxfs follows cellStyles (or cellXfs when there are no cell styles)
+		element := `<dxfs count="` + strconv.Itoa(len(dxfs)) + `">` + strings.Join(dxfs, "") + `</dxfs>`
+		at := bytes.Index(styles, []byte("</cellStyles>"))
+		if at >= 0 {
+			at += len("</cellStyles>")
+		} else if at = bytes.Index(styles, []byte("</cellXfs>")); at >= 0 {
+			at += len("</cellXfs>")
+		} else {
+			return nil, errors.New("no cellXfs in xl/styles.xml")
+		}
+		styles = append(styles[0:at:at], append([]byte(element), styles[at:]...)...)
+	}
+	parts["xl/styles.xml"] = styles
+
+	// The rules for a sheet share one conditionalFormatting element per range
+	rules := map[string][]string{}
+	ranges := map[string][]string{}
+	for i, h := range highlights {
+		n, ok := sheets[h.Sheet]
+		if ok == false {
+			return nil, errors.New("no sheet " + h.Sheet)
+		}
+		name := "xl/worksheets/sheet" + strconv.Itoa(n) + ".xml"
+		if _, ok := rules[name+" "+h.Range]; ok == false {
+			ranges[name] = append(ranges[name], h.Range)
+		}
+		rules[name+" "+h.Range] = append(rules[name+" "+h.Range], `<cfRule type="expression" dxfId="`+strconv.Itoa(first+i)+`" priority="`+strconv.Itoa(i+1)+`"><formula>`+xmlText(h.Formula)+`</formula></cfRule>`)
+	}
+	for name, sheetRanges := range ranges {
+		sheet, ok := parts[name]
+		if ok == false {
+			return nil, errors.New("no " + name)
+		}
+		formats := ""
+		for _, r := range sheetRanges {
+			formats += `<conditionalFormatting sqref="` + r + `">` + strings.Join(rules[name+" "+r], "") + `</conditionalFormatting>`
+		}
+		at := -1
+		for _, tag := range afterConditionalFormats {
+			if at = bytes.Index(sheet, []byte(tag)); at >= 0 {
+				break
+			}
+		}
+		if at < 0 {
+			return nil, errors.New("no worksheet element in " + name)
+		}
+		parts[name] = append(sheet[0:at:at], append([]byte(formats), sheet[at:]...)...)
+	}
+
+	// The parts are written back in their original order
+	out := new(bytes.Buffer)
+	zw := zip.NewWriter(out)
+	for _, f := range zr.File {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(parts[f.Name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// styleResultSheet makes the header row bold and frozen, turns on the autofilter and sizes the columns to fit
+func styleResultSheet(sheet *xlsx.Sheet) error {
+	if len(sheet.Rows) == 0 {
+		return nil
+	}
+	header := sheet.Rows[0]
+	for _, cell := range header.Cells {
+		style := cell.GetStyle()
+		style.Font.Bold = true
+		style.ApplyFont = true
+		style.Fill = *xlsx.NewFill("solid", headerFill, headerFill)
+		style.ApplyFill = true
+		cell.SetStyle(style)
+	}
+
+	// Freeze the header so it stays visible while scrolling
+	sheet.SheetViews = []xlsx.SheetView{
+		{
+			Pane: &xlsx.Pane{
+				YSplit:      1.0,
+				TopLeftCell: "A2",
+				ActivePane:  "bottomLeft",
+				State:       "frozen",
+			},
+		},
+	}
+
+	lastCol := len(header.Cells) - 1
+	sheet.AutoFilter = &xlsx.AutoFilter{
+		TopLeftCell:     "A1",
+		BottomRightCell: xlsx.GetCellIDStringFromCoords(lastCol, len(sheet.Rows)-1),
+	}
+
+	// Size each column to its longest value within reason, long text wraps
+	for col := 0; col <= lastCol; col++ {
+		width := minColWidth
+		for _, row := range sheet.Rows {
+			if col < len(row.Cells) {
+				if w := float64(len([]rune(row.Cells[col].Value))) + 2.0; w > width {
+					width = w
+				}
+			}
+		}
+		if width > maxColWidth {
+			width = maxColWidth
+		}
+		if err := sheet.SetColWidth(col, col, width); err != nil {
+			return errors.New("Can't set width of column " + xlsx.ColIndexToLetters(col) + ", " + err.Error())
+		}
+	}
+	return nil
+}
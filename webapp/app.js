@@ -1,81 +1,180 @@
 (function (doc, win) {
     "use strict";
-    var eprintsSearchURL = document.getElementById("eprintsSearchURL"),
-        dataPath = document.getElementById("dataPath"),
-        overwriteResult = document.getElementById("overwriteResult"),
-        sheetName = document.getElementById("sheetName"),
-        workbook = document.getElementById("workbook"),
-        queryColumn = document.getElementById("queryColumn"),
-        resultColumn = document.getElementById("resultColumn"),
-        runButton = document.getElementById("xlqRun"),
-        resultBlock = document.getElementById("xlrBlock"),
+    var eprintsSearchURL = doc.getElementById("eprintsSearchURL"),
+        overwriteResult = doc.getElementById("overwriteResult"),
+        skipFirstRow = doc.getElementById("skipFirstRow"),
+        sheetName = doc.getElementById("sheetName"),
+        workbook = doc.getElementById("workbook"),
+        queryColumn = doc.getElementById("queryColumn"),
+        resultSheet = doc.getElementById("resultSheet"),
+        resultFields = doc.getElementById("resultFields"),
+        statusColumn = doc.getElementById("statusColumn"),
+        scoreMatches = doc.getElementById("scoreMatches"),
+        styleResults = doc.getElementById("styleResults"),
+        preview = doc.getElementById("xlqPreview"),
+        progress = doc.getElementById("xlqProgress"),
+        statusLine = doc.getElementById("xlqStatus"),
+        runButton = doc.getElementById("xlqRun"),
+        resultBlock = doc.getElementById("xlrBlock"),
+        previewRows = 5,
+        sheets = [],
+        workbookName = "",
         dataURL = "",
-        xlq = xlquery.New();
-
-    
-    workbook.addEventListener("change", function (evt)  {
-      var fp = evt.target.files[0] || {},
-          files = this.files,
-          reader = new FileReader();
-
-      xlq.WorkbookName = fp.fileName || ""; 
-      console.log("DEBUG evt.target.files", evt.target.files[0]);
-      reader.onload = function (eFile) {
-          console.log("DEBUG eFile.target.result (data url)", eFile.target.result.substring(0, 64));
-          dataURL = eFile.target.result;
-      };
-      reader.readAsDataURL(files[0]);
-    }, false)
+        xlq = excelquery.New();
 
-    runButton.addEventListener("click", function (evt) {
-        var isAlpha = new RegExp("^[a-z,A-Z]+$", "g"),
-            isDataURL = new RegExp("^data\:application/","i");
-
-        console.log("DEBUG runButton clicked");
+    function showMessage(msg) {
+        var pre = doc.createElement("pre");
+        pre.textContent = msg;
+        resultBlock.innerHTML = "";
+        resultBlock.appendChild(pre);
+    }
 
-        /* Validate queryColumn */
-        if (!queryColumn.value.match(isAlpha)) {
-            console.log("ERROR: queryColumn show be in the for A, AA, ABC", queryColumn);
-            return;
+    /* columnName turns a zero based column index into A, B, ..., Z, AA, AB, ... */
+    function columnName(i) {
+        var s = "";
+        i += 1;
+        while (i > 0) {
+            s = String.fromCharCode(65 + ((i - 1) % 26)) + s;
+            i = Math.floor((i - 1) / 26);
         }
-        xlq.QueryColumn = queryColumn.value.trim();
+        return s;
+    }
 
-        /* Validate resultColumn */
-        if (!resultColumn.value.match(isAlpha)) {
-            console.log("ERROR: resultColumn show be in the for A, AA, ABC", resultColumn);
-            return;
-        }
-        xlq.ResultColumn = resultColumn.value.trim();
+    function addOption(sel, value, label) {
+        var opt = doc.createElement("option");
+        opt.value = value;
+        opt.textContent = label;
+        sel.appendChild(opt);
+    }
 
-        /* Validate overwriteResult */
-        if (overwriteResult.checked === true) {
-            xlq.OverwriteResult = true;
-        } else {
-            xlq.OverwriteResult = false;
-        }
+    /* showPreview renders the first rows of each sheet as a table */
+    function showPreview() {
+        preview.innerHTML = "";
+        sheets.forEach(function (sheet) {
+            var h = doc.createElement("h3"),
+                table = doc.createElement("table"),
+                tr = doc.createElement("tr"),
+                i;
+
+            h.textContent = sheet.name;
+            preview.appendChild(h);
+            for (i = 0; i < sheet.columns; i++) {
+                tr.appendChild(doc.createElement("th")).textContent = columnName(i);
+            }
+            table.appendChild(tr);
+            sheet.rows.forEach(function (row) {
+                tr = doc.createElement("tr");
+                for (i = 0; i < sheet.columns; i++) {
+                    tr.appendChild(doc.createElement("td")).textContent = row[i] || "";
+                }
+                table.appendChild(tr);
+            });
+            preview.appendChild(table);
+        });
+    }
+
+    /* updateColumns offers the columns of the chosen sheet, labelled by their first row */
+    function updateColumns() {
+        var sheet = sheets[sheetName.selectedIndex] || {columns: 0, rows: []},
+            header = sheet.rows[0] || [],
+            i;
 
-        /* Validate sheetName */
-        if (sheetName.value.trim() === "") {
-            xlq.SheetName = "Sheet1";
-        } else {
-            xlq.SheetName = sheetName.value.trim();
+        queryColumn.innerHTML = "";
+        for (i = 0; i < sheet.columns; i++) {
+            addOption(queryColumn, columnName(i), columnName(i) + (header[i] ? " (" + header[i] + ")" : ""));
         }
+        runButton.disabled = (sheet.columns === 0);
+    }
+
+    /* The result field choices come from the package so they match the command line tool */
+    JSON.parse(xlq.ResultFields()).forEach(function (field) {
+        var label = doc.createElement("label"),
+            cb = doc.createElement("input");
+
+        cb.type = "checkbox";
+        cb.value = field.path;
+        cb.checked = (field.label === "Title" || field.label === "Link");
+        label.appendChild(cb);
+        label.appendChild(doc.createTextNode(" " + field.label + " "));
+        resultFields.appendChild(label);
+    });
 
-        /* Validate dataPath */
-        if (dataPath.value.trim() === "") {
-            xlq.DataPath = ".item[].link";
-        } else {
-            xlq.DataPath = dataPath.value.trim();
+    workbook.addEventListener("change", function (evt) {
+        var fp = evt.target.files[0],
+            reader = new FileReader();
+
+        if (fp === undefined) {
+            return;
         }
+        workbookName = fp.name || "Untitled.xlsx";
+        reader.onload = function (eFile) {
+            dataURL = eFile.target.result;
+            sheets = JSON.parse(xlq.SheetPreview(dataURL, previewRows));
+            if (sheets.length === 0) {
+                showMessage(xlq.Errors());
+            }
+            sheetName.innerHTML = "";
+            sheets.forEach(function (sheet) {
+                addOption(sheetName, sheet.name, sheet.name);
+            });
+            showPreview();
+            updateColumns();
+        };
+        reader.readAsDataURL(fp);
+    }, false);
 
-        /* Validate dataURL */
-        if (!dataURL.match(isDataURL)) {
-            console.log("ERROR: not a dataURL ["+dataURL+"]");
+    sheetName.addEventListener("change", updateColumns, false);
+
+    runButton.addEventListener("click", function (evt) {
+        var paths = [];
+
+        Array.prototype.forEach.call(resultFields.querySelectorAll("input:checked"), function (cb) {
+            paths.push(cb.value);
+        });
+        if (paths.length === 0) {
+            showMessage("Choose at least one result field");
             return;
         }
-        console.log("DEBUG got all the way to call xlquery.Run()");
-        dataURL = xlq.WebRunner(dataURL);
-        console.log("DEBUG output dataURL: ", dataURL);
-        xlrBlock.innerHTML = "<pre>"+dataURL+"</pre>";
+        xlq.SetOption("WorkbookName", workbookName);
+        xlq.SetOption("SheetName", sheetName.value);
+        xlq.SetOption("QueryColumn", queryColumn.value);
+        xlq.SetOption("SkipFirstRow", skipFirstRow.checked ? "true" : "false");
+        if (eprintsSearchURL.value.trim() !== "") {
+            xlq.SetOption("EPrintsSearchURL", eprintsSearchURL.value.trim());
+        }
+        xlq.SetOption("ResultDataPaths", paths.join(","));
+        xlq.SetOption("ResultSheetName", resultSheet.value.trim() || "Result1");
+        xlq.SetOption("OverwriteResult", overwriteResult.checked ? "true" : "false");
+        xlq.SetOption("StatusColumn", statusColumn.value.trim().toUpperCase());
+        xlq.SetOption("ScoreMatches", scoreMatches.checked ? "true" : "false");
+        xlq.SetOption("StyleResults", styleResults.checked ? "true" : "false");
+
+        runButton.disabled = true;
+        resultBlock.innerHTML = "";
+        progress.hidden = false;
+        progress.value = 0;
+        statusLine.textContent = "Running queries ...";
+        xlq.Run(dataURL, function (done, total) {
+            progress.max = total;
+            progress.value = done;
+            statusLine.textContent = "Row " + done + " of " + total;
+        }, function (output) {
+            var link;
+
+            runButton.disabled = false;
+            progress.hidden = true;
+            if (output === "") {
+                statusLine.textContent = "Failed";
+                showMessage(xlq.Errors());
+                return;
+            }
+            statusLine.textContent = "Done";
+            showMessage(xlq.Messages() + "\n" + xlq.Errors());
+            link = doc.createElement("a");
+            link.href = output;
+            link.download = workbookName;
+            link.textContent = "Download " + workbookName;
+            resultBlock.appendChild(link);
+        });
     }, false);
 }(document, window))
@@ -26,10 +26,25 @@ import (
 	"github.com/gopherjs/gopherjs/js"
 )
 
+// webApp adds the browser specific methods to an XLQuery, the embedded XLQuery's methods remain available
+type webApp struct {
+	*excelquery.XLQuery
+}
+
+// Run runs the queries in the background, calling progress after each row and done with the
+// updated workbook as a data URL (empty if it failed, see Errors()). Requests block so they
+// can't be made from the browser's event loop directly.
+func (app *webApp) Run(dataURL string, progress func(int, int), done func(string)) {
+	app.Progress = progress
+	go func() {
+		done(app.WebRunner(dataURL))
+	}()
+}
+
 func New() *js.Object {
 	xlq := new(excelquery.XLQuery)
 	xlq.Init()
-	return js.MakeWrapper(xlq)
+	return js.MakeWrapper(&webApp{xlq})
 }
 
 func main() {